@@ -25,6 +25,12 @@ func (c *Chunk) WriteChunk(code interface{}, line int) {
 	c.Count++
 }
 
+// Jumps are written with a placeholder operand and
+// patched once the size of the jumped-over code is known.
+func (c *Chunk) PatchJump(offset uint) {
+	c.Code[offset] = c.Count - offset - 1
+}
+
 func FreeChunk(chunk *Chunk) {
 
 }
@@ -48,6 +54,50 @@ func ByteInstruction(name string, chunk *Chunk, offset uint) uint {
 	return offset + 2
 }
 
+// TypedConstant prints a constant followed by a type annotation operand.
+func TypedConstant(name string, chunk *Chunk, offset uint) uint {
+	constant := chunk.Code[offset+1].(uint)
+	tag := chunk.Code[offset+2].(value.TypeTag)
+	fmt.Printf("%-16s %d '", name, constant)
+	value.PrintValue(chunk.Constants.Values[constant])
+	fmt.Printf("' %s\n", value.TTmap[tag])
+	return offset + 3
+}
+
+// TypedByteInstruction prints a slot followed by a type annotation operand.
+func TypedByteInstruction(name string, chunk *Chunk, offset uint) uint {
+	slot := chunk.Code[offset+1].(uint)
+	tag := chunk.Code[offset+2].(value.TypeTag)
+	fmt.Printf("%-16s %4d %s\n", name, slot, value.TTmap[tag])
+	return offset + 3
+}
+
+func JumpInstruction(name string, sign int, chunk *Chunk, offset uint) uint {
+	jump := chunk.Code[offset+1].(uint)
+	target := int(offset) + 2 + sign*int(jump)
+	fmt.Printf("%-16s %4d -> %d\n", name, offset, target)
+	return offset + 2
+}
+
+func ClosureInstruction(name string, chunk *Chunk, offset uint) uint {
+	constant := chunk.Code[offset+1].(uint)
+	fn := chunk.Constants.Values[constant]
+	fmt.Printf("%-16s %d '", name, constant)
+	value.PrintValue(fn)
+	fmt.Printf("'\n")
+
+	offset += 2
+	for i := 0; i < value.AsFunction(&fn).UpvalueCount; i++ {
+		kind := "upvalue"
+		if chunk.Code[offset].(uint) == 1 {
+			kind = "local"
+		}
+		fmt.Printf("%04d    |                     %s %d\n", offset, kind, chunk.Code[offset+1].(uint))
+		offset += 2
+	}
+	return offset
+}
+
 func DissasInstruction(chunk *Chunk, offset uint) uint {
 	fmt.Printf("%04d ", offset)
 	fmt.Printf("%4d ", chunk.Lines[offset])
@@ -61,9 +111,29 @@ func DissasInstruction(chunk *Chunk, offset uint) uint {
 	case codes.INSTRUC_SUBSTRACT:
 		return OpInstruction("INSTRUC_SUBSTRACT", offset)
 	case codes.INSTRUC_MULTIPLY:
-		return OpInstruction("INSTRUC_DIVIDE", offset)
+		return OpInstruction("INSTRUC_MULTIPLY", offset)
 	case codes.INSTRUC_DIVIDE:
 		return OpInstruction("INSTRUC_DIVIDE", offset)
+	case codes.INSTRUC_MODULO:
+		return OpInstruction("INSTRUC_MODULO", offset)
+	case codes.INSTRUC_FLOOR_DIVIDE:
+		return OpInstruction("INSTRUC_FLOOR_DIVIDE", offset)
+	case codes.INSTRUC_POWER:
+		return OpInstruction("INSTRUC_POWER", offset)
+	case codes.INSTRUC_TO_STRING:
+		return OpInstruction("INSTRUC_TO_STRING", offset)
+	case codes.INSTRUC_BIT_AND:
+		return OpInstruction("INSTRUC_BIT_AND", offset)
+	case codes.INSTRUC_BIT_OR:
+		return OpInstruction("INSTRUC_BIT_OR", offset)
+	case codes.INSTRUC_BIT_XOR:
+		return OpInstruction("INSTRUC_BIT_XOR", offset)
+	case codes.INSTRUC_BIT_NOT:
+		return OpInstruction("INSTRUC_BIT_NOT", offset)
+	case codes.INSTRUC_SHIFT_LEFT:
+		return OpInstruction("INSTRUC_SHIFT_LEFT", offset)
+	case codes.INSTRUC_SHIFT_RIGHT:
+		return OpInstruction("INSTRUC_SHIFT_RIGHT", offset)
 	case codes.INSTRUC_NEGATE:
 		return OpInstruction("INSTRUC_NEGATE", offset)
 	case codes.INSTRUC_NOT:
@@ -87,15 +157,55 @@ func DissasInstruction(chunk *Chunk, offset uint) uint {
 	case codes.INSTRUC_POP:
 		return OpInstruction("INSTRUC_POP", offset)
 	case codes.INSTRUC_DECL_GLOBAL:
-		return PrintConstant("INSTRUC_DECL_GLOBAL", chunk, offset)
+		return TypedConstant("INSTRUC_DECL_GLOBAL", chunk, offset)
 	case codes.INSTRUC_SET_DECL_GLOBAL:
 		return PrintConstant("INSTRUC_SET_DECL_GLOBAL", chunk, offset)
 	case codes.INSTRUC_GET_DECL_GLOBAL:
 		return PrintConstant("INSTRUC_GET_DECL_GLOBAL", chunk, offset)
 	case codes.INSTRUC_SET_DECL_LOCAL:
-		return ByteInstruction("INSTRUC_SET_DECL_LOCAL", chunk, offset)
+		return TypedByteInstruction("INSTRUC_SET_DECL_LOCAL", chunk, offset)
 	case codes.INSTRUC_GET_DECL_LOCAL:
 		return ByteInstruction("INSTRUC_GET_DECL_LOCAL", chunk, offset)
+	case codes.INSTRUC_JUMP:
+		return JumpInstruction("INSTRUC_JUMP", 1, chunk, offset)
+	case codes.INSTRUC_JUMP_IF_FALSE:
+		return JumpInstruction("INSTRUC_JUMP_IF_FALSE", 1, chunk, offset)
+	case codes.INSTRUC_LOOP:
+		return JumpInstruction("INSTRUC_LOOP", -1, chunk, offset)
+	case codes.INSTRUC_ITER_NEXT:
+		return ByteInstruction("INSTRUC_ITER_NEXT", chunk, offset)
+	case codes.INSTRUC_CALL:
+		return ByteInstruction("INSTRUC_CALL", chunk, offset)
+	case codes.INSTRUC_CLOSURE:
+		return ClosureInstruction("INSTRUC_CLOSURE", chunk, offset)
+	case codes.INSTRUC_GET_UPVALUE:
+		return ByteInstruction("INSTRUC_GET_UPVALUE", chunk, offset)
+	case codes.INSTRUC_SET_UPVALUE:
+		return TypedByteInstruction("INSTRUC_SET_UPVALUE", chunk, offset)
+	case codes.INSTRUC_CLOSE_UPVALUE:
+		return OpInstruction("INSTRUC_CLOSE_UPVALUE", offset)
+	case codes.INSTRUC_ARRAY:
+		return ByteInstruction("INSTRUC_ARRAY", chunk, offset)
+	case codes.INSTRUC_MAP:
+		return ByteInstruction("INSTRUC_MAP", chunk, offset)
+	case codes.INSTRUC_GET_INDEX:
+		return OpInstruction("INSTRUC_GET_INDEX", offset)
+	case codes.INSTRUC_SET_INDEX:
+		return OpInstruction("INSTRUC_SET_INDEX", offset)
+	case codes.INSTRUC_SLICE:
+		return OpInstruction("INSTRUC_SLICE", offset)
+	case codes.INSTRUC_CLASS:
+		return PrintConstant("INSTRUC_CLASS", chunk, offset)
+	case codes.INSTRUC_METHOD:
+		return PrintConstant("INSTRUC_METHOD", chunk, offset)
+	case codes.INSTRUC_GET_PROPERTY:
+		return PrintConstant("INSTRUC_GET_PROPERTY", chunk, offset)
+	case codes.INSTRUC_SET_PROPERTY:
+		return PrintConstant("INSTRUC_SET_PROPERTY", chunk, offset)
+	case codes.INSTRUC_INHERIT:
+		return OpInstruction("INSTRUC_INHERIT", offset)
+	case codes.INSTRUC_GET_SUPER:
+		return PrintConstant("INSTRUC_GET_SUPER", chunk, offset)
 	}
 	// NOTE: should never reach!
 	return 0
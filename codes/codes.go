@@ -9,6 +9,17 @@ const (
 	INSTRUC_SUBSTRACT
 	INSTRUC_MULTIPLY
 	INSTRUC_DIVIDE
+	INSTRUC_MODULO
+	INSTRUC_FLOOR_DIVIDE
+	INSTRUC_POWER
+
+	// integers only
+	INSTRUC_BIT_AND
+	INSTRUC_BIT_OR
+	INSTRUC_BIT_XOR
+	INSTRUC_BIT_NOT
+	INSTRUC_SHIFT_LEFT
+	INSTRUC_SHIFT_RIGHT
 
 	INSTRUC_CONSTANT
 	INSTRUC_NEGATE
@@ -32,6 +43,32 @@ const (
 	INSTRUC_NIL
 	INSTRUC_POP
 
+	INSTRUC_JUMP
+	INSTRUC_JUMP_IF_FALSE
+	INSTRUC_LOOP
+	INSTRUC_ITER_NEXT
+
+	INSTRUC_CALL
+	INSTRUC_CLOSURE
+	INSTRUC_GET_UPVALUE
+	INSTRUC_SET_UPVALUE
+	INSTRUC_CLOSE_UPVALUE
+
+	INSTRUC_ARRAY
+	INSTRUC_MAP
+	INSTRUC_GET_INDEX
+	INSTRUC_SET_INDEX
+	INSTRUC_SLICE
+
+	INSTRUC_CLASS
+	INSTRUC_METHOD
+	INSTRUC_GET_PROPERTY
+	INSTRUC_SET_PROPERTY
+	INSTRUC_INHERIT
+	INSTRUC_GET_SUPER
+
+	INSTRUC_TO_STRING
+
 	INSTRUC_PRINT
 	INSTRUC_RETURN
 	INSTRUC_ERR
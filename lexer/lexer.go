@@ -3,6 +3,7 @@ package lexer
 import (
 	"fmt"
 	"os"
+	"strconv"
 	"strings"
 	"unicode"
 	"unicode/utf8"
@@ -17,6 +18,16 @@ type Lexer struct {
 	start        int
 	tokens       chan token.Token
 	requiresSemi bool
+	// byte size of the last rune read
+	width int
+	// open ${ of strings, innermost last
+	interpolations []interpolation
+}
+
+type interpolation struct {
+	multiline bool
+	// '{' opened inside the expression, the '}' at 0 closes it
+	braces int
 }
 
 type stateFunc func(*Lexer) stateFunc
@@ -26,20 +37,27 @@ func IsLetter(ch rune) bool       { return unicode.IsLetter(ch) }
 func IsAlphaNumeric(ch rune) bool { return (IsLetter(ch) || IsDigit(ch)) }
 
 func (lex *Lexer) reportError(reason string) {
+	lex.reportErrorAt(lex.position, reason)
+}
+
+func (lex *Lexer) reportErrorAt(position int, reason string) {
 	fmt.Fprintf(os.Stderr, "[line:%d, pos:%d], %s\n",
-		lex.line, lex.position, reason)
+		lex.line, position, reason)
 }
 
+// unread steps back over the last rune read, once.
 func (lex *Lexer) unread() {
-	lex.position--
+	lex.position -= lex.width
 }
 
 func (lex *Lexer) read() rune {
 	if lex.position >= len(lex.input) {
+		lex.width = 0
 		return token.EoF
 	}
-	ch, _ := utf8.DecodeRuneInString(lex.input[lex.position:])
-	lex.position++
+	ch, width := utf8.DecodeRuneInString(lex.input[lex.position:])
+	lex.width = width
+	lex.position += width
 	return ch
 }
 
@@ -87,11 +105,17 @@ func (lex *Lexer) skipComment() {
 }
 
 func (lex *Lexer) emit(tokenType token.TokenType) {
+	lex.emitValue(tokenType, lex.input[lex.start:lex.position])
+}
+
+// emitValue emits a token whose value differs from its source, like
+// a string with escapes.
+func (lex *Lexer) emitValue(tokenType token.TokenType, value string) {
 	tkn := token.Token{
 		Type:     tokenType,
 		Position: lex.position,
 		Line:     lex.line,
-		Value:    lex.input[lex.start:lex.position],
+		Value:    value,
 	}
 	lex.tokens <- tkn
 	lex.start = lex.position
@@ -115,20 +139,85 @@ func (lex *Lexer) scanNumber() bool {
 	lex.unread()
 	lex.start = lex.position
 
+	/* ACCEPT 0x, 0o, 0b PREFIXES */
+	if lex.accept("0") {
+		if kind, digits, found := lex.acceptBase(); found {
+			count, ok := lex.scanDigits(digits, kind, true)
+			if !ok {
+				return false
+			}
+			if count == 0 {
+				lex.reportError(fmt.Sprintf("SyntaxError, %s literal has no digits.", kind))
+				return false
+			}
+			return lex.endNumber(kind)
+		}
+		lex.unread()
+	}
+
 	/* ACCEPT DIGITS */
 	// token.INIT
 
 	digits := "0123456789"
-	lex.acceptRun(digits)
+	if _, ok := lex.scanDigits(digits, "decimal", false); !ok {
+		return false
+	}
 
 	dot := "."
 	/* ACCEPT DIGITS.DIGITS */
 	if lex.accept(dot) {
 		// token.FLOAT
-		lex.acceptRun(digits)
+		if _, ok := lex.scanDigits(digits, "decimal", false); !ok {
+			return false
+		}
 	}
 
-	if IsAlphaNumeric(lex.peek()) {
+	/* ACCEPT imaginary part, 4i */
+	lex.accept("i")
+
+	return lex.endNumber("decimal")
+}
+
+func (lex *Lexer) acceptBase() (kind string, digits string, found bool) {
+	switch {
+	case lex.accept("xX"):
+		return "hexadecimal", "0123456789abcdefABCDEF", true
+	case lex.accept("oO"):
+		return "octal", "01234567", true
+	case lex.accept("bB"):
+		return "binary", "01", true
+	}
+	return "", "", false
+}
+
+// scanDigits accepts a run of digits where a single '_' separates two
+// digits, 1_000. afterDigit allows a leading '_' after a prefix, 0x_FF.
+func (lex *Lexer) scanDigits(digits string, kind string, afterDigit bool) (count int, ok bool) {
+	for {
+		ch := lex.peek()
+		if ch == '_' {
+			lex.read()
+			if !afterDigit || !strings.ContainsRune(digits, lex.peek()) {
+				// point at the '_'
+				lex.unread()
+				lex.reportError(fmt.Sprintf("SyntaxError, '_' must separate successive digits in %s literal.", kind))
+				return count, false
+			}
+			continue
+		}
+		if !strings.ContainsRune(digits, ch) {
+			return count, true
+		}
+		lex.read()
+		count++
+		afterDigit = true
+	}
+}
+
+// endNumber rejects letters and digits glued to a number, 0b12 or 11a.
+func (lex *Lexer) endNumber(kind string) bool {
+	if ch := lex.peek(); IsAlphaNumeric(ch) || ch == '_' {
+		lex.reportError(fmt.Sprintf("SyntaxError, invalid character %q in %s literal.", ch, kind))
 		return false
 	}
 	return true
@@ -167,22 +256,140 @@ func (lex *Lexer) scanConditions(rcurrent token.TokenType, rfuture token.TokenTy
 	return rcurrent
 }
 
-func (lex *Lexer) scanString() bool {
-	lex.start = lex.position
+// scanString decodes a "..." literal after its opening quote or the
+// '}' of an interpolation, a """...""" literal may span lines. A ${
+// ends the segment with an INTERPOLATION and the expression follows.
+func (lex *Lexer) scanString(multiline bool) (token.TokenType, string) {
+	start := lex.position
+	var value strings.Builder
 	for {
+		if multiline && strings.HasPrefix(lex.input[lex.position:], `"""`) {
+			lex.position += len(`"""`)
+			return token.STRING, value.String()
+		}
+		if strings.HasPrefix(lex.input[lex.position:], "${") {
+			lex.position += len("${")
+			lex.interpolations = append(lex.interpolations, interpolation{multiline: multiline})
+			return token.INTERPOLATION, value.String()
+		}
 		ch := lex.read()
-		if ch == '"' {
-			// don't consume '"'
-			lex.unread()
-			break
+		switch {
+		case ch == '"' && !multiline:
+			return token.STRING, value.String()
+		case ch == token.EoF || ch == '\n' && !multiline:
+			lex.reportErrorAt(start, "SyntaxError, unterminated string.")
+			return token.ERR, ""
+		case ch == '\\':
+			if !lex.scanEscape(&value) {
+				return token.ERR, ""
+			}
+		default:
+			if ch == '\n' {
+				lex.line++
+			}
+			value.WriteRune(ch)
 		}
-		if ch == '\n' || ch == token.EoF {
-			return false
+	}
+}
+
+var escapes = map[rune]rune{
+	'n':  '\n',
+	't':  '\t',
+	'r':  '\r',
+	'0':  0,
+	'\\': '\\',
+	'"':  '"',
+	'\'': '\'',
+	'$':  '$',
+}
+
+// scanEscape decodes the escape after a backslash, \n or \u00e9.
+func (lex *Lexer) scanEscape(value *strings.Builder) bool {
+	backslash := lex.position - 1
+	ch := lex.read()
+	if r, found := escapes[ch]; found {
+		value.WriteRune(r)
+		return true
+	}
+	size := map[rune]int{'u': 4, 'U': 8}[ch]
+	if size == 0 {
+		if ch == token.EoF {
+			lex.reportErrorAt(backslash, "SyntaxError, unterminated string.")
+		} else {
+			lex.reportErrorAt(backslash, fmt.Sprintf("SyntaxError, unknown escape sequence '\\%c'.", ch))
 		}
+		return false
+	}
+	end := lex.position + size
+	if end > len(lex.input) {
+		end = len(lex.input)
+	}
+	digits := lex.input[lex.position:end]
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) != size || !utf8.ValidRune(rune(code)) {
+		lex.reportErrorAt(backslash, fmt.Sprintf("SyntaxError, invalid unicode escape '\\%c%s', expected %d hex digits.", ch, digits, size))
+		return false
+	}
+	lex.position = end
+	value.WriteRune(rune(code))
+	return true
+}
+
+func (lex *Lexer) emitString(multiline bool) bool {
+	tokenType, value := lex.scanString(multiline)
+	if tokenType == token.ERR {
+		lex.emit(token.ERR)
+		return false
 	}
+	lex.emitValue(tokenType, value)
 	return true
 }
 
+// scanChar decodes a 'a' or '\n' literal after its opening quote.
+func (lex *Lexer) scanChar() (string, bool) {
+	start := lex.position
+	var value strings.Builder
+	ch := lex.read()
+	switch {
+	case ch == '\'':
+		lex.reportErrorAt(start, "SyntaxError, empty character literal.")
+		return "", false
+	case ch == token.EoF || ch == '\n':
+		lex.reportErrorAt(start, "SyntaxError, unterminated character literal.")
+		return "", false
+	case ch == '\\':
+		if !lex.scanEscape(&value) {
+			return "", false
+		}
+	default:
+		value.WriteRune(ch)
+	}
+	switch lex.read() {
+	case '\'':
+		return value.String(), true
+	case token.EoF, '\n':
+		lex.reportErrorAt(start, "SyntaxError, unterminated character literal.")
+	default:
+		lex.reportErrorAt(start, "SyntaxError, character literal must hold one character.")
+	}
+	return "", false
+}
+
+// scanRawString reads a `...` literal after its opening backtick,
+// without escapes and across lines.
+func (lex *Lexer) scanRawString() (string, bool) {
+	start := lex.position
+	end := strings.IndexRune(lex.input[start:], '`')
+	if end < 0 {
+		lex.reportErrorAt(start, "SyntaxError, unterminated raw string.")
+		return "", false
+	}
+	value := lex.input[start : start+end]
+	lex.line += strings.Count(value, "\n")
+	lex.position = start + end + 1
+	return value, true
+}
+
 func fullScan(lex *Lexer) stateFunc {
 	for {
 		ch := lex.read()
@@ -191,8 +398,8 @@ func fullScan(lex *Lexer) stateFunc {
 		case IsDigit(ch1):
 			done := lex.scanNumber()
 			if !done {
+				// scanNumber reports where the literal went wrong
 				lex.emit(token.ERR)
-				lex.reportError("SyntaxError, number malformed.")
 				return nil
 			}
 			lex.emit(token.NUMBER)
@@ -227,11 +434,33 @@ func fullScan(lex *Lexer) stateFunc {
 				lex.emit(token.MINUS)
 				lex.setRequiresSemi(true)
 			case '/':
-				lex.emit(token.SLASH)
+				if lex.accept("/") {
+					lex.emit(token.SLASH_SLASH)
+				} else {
+					lex.emit(token.SLASH)
+				}
 				lex.setRequiresSemi(true)
 			case '*':
-				lex.emit(token.STAR)
+				if lex.accept("*") {
+					lex.emit(token.STAR_STAR)
+				} else {
+					lex.emit(token.STAR)
+				}
 				lex.setRequiresSemi(true)
+			case '%':
+				lex.emit(token.PERCENT)
+				lex.setRequiresSemi(true)
+			case '&':
+				lex.emit(token.AMPERSAND)
+				lex.setRequiresSemi(true)
+			case '|':
+				lex.emit(token.PIPE)
+				lex.setRequiresSemi(true)
+			case '^':
+				lex.emit(token.CARET)
+				lex.setRequiresSemi(true)
+			case '~':
+				lex.emit(token.TILDE)
 			case '(':
 				lex.emit(token.OP)
 				lex.setRequiresSemi(false)
@@ -239,24 +468,57 @@ func fullScan(lex *Lexer) stateFunc {
 				lex.emit(token.CP)
 				lex.setRequiresSemi(true)
 			case '{':
+				if n := len(lex.interpolations); n > 0 {
+					lex.interpolations[n-1].braces++
+				}
 				lex.emit(token.LB)
+				lex.setRequiresSemi(false)
 			case '}':
+				if n := len(lex.interpolations); n > 0 {
+					if lex.interpolations[n-1].braces == 0 {
+						// end of ${...}, the string goes on
+						open := lex.interpolations[n-1]
+						lex.interpolations = lex.interpolations[:n-1]
+						if !lex.emitString(open.multiline) {
+							return nil
+						}
+						break
+					}
+					lex.interpolations[n-1].braces--
+				}
 				lex.emit(token.RB)
+				lex.setRequiresSemi(true)
+			case '[':
+				lex.emit(token.LSB)
+				lex.setRequiresSemi(false)
+			case ']':
+				lex.emit(token.RSB)
+				lex.setRequiresSemi(true)
 			case ',':
 				lex.emit(token.COMMA)
+				// a trailing ',' continues on the next line
+				lex.setRequiresSemi(false)
 			case '.':
+				if !IsDigit(lex.peek()) {
+					// property access, a.b
+					lex.emit(token.DOT)
+					break
+				}
 				done := lex.scanNumber()
 				if !done {
-					lex.reportError("SyntaxError, number malformed.")
 					lex.emit(token.ERR)
 					return nil
 				}
 				lex.emit(token.NUMBER)
 			case ';':
-				// TODO: is it needed?
-				// lex.emit(token.SEMICOLON)
+				lex.emit(token.SEMICOLON)
+				lex.setRequiresSemi(false)
 			case ':':
 				lex.emit(token.COLON)
+			case '?':
+				lex.emit(token.QUESTION)
+				// the branches may start on the next line
+				lex.setRequiresSemi(false)
 			case '!':
 				// TODO: is it a condition first
 				rtoken := lex.scanConditions(token.EXCL, token.EXCL_EQUAL)
@@ -267,24 +529,43 @@ func fullScan(lex *Lexer) stateFunc {
 				lex.emit(rtoken)
 			case '<':
 				// TODO: is it a condition first
+				if lex.accept("<") {
+					lex.emit(token.LESS_LESS)
+					break
+				}
 				rtoken := lex.scanConditions(token.LESS, token.LESS_EQUAL)
 				lex.emit(rtoken)
 			case '>':
 				// TODO: is it a condition first
+				if lex.accept(">") {
+					lex.emit(token.GREATER_GREATER)
+					break
+				}
 				rtoken := lex.scanConditions(token.GREATER, token.GREATER_EQUAL)
 				lex.emit(rtoken)
 			case '\'':
-				lex.emit(token.SINGLE_QUOTE)
+				value, ok := lex.scanChar()
+				if !ok {
+					lex.emit(token.ERR)
+					return nil
+				}
+				lex.emitValue(token.CHAR, value)
 			case '"':
-				if lex.scanString() {
-					lex.emit(token.STRING)
-					// consume the trailing '"'
-					lex.read()
-				} else {
-					lex.reportError("Wrong string formatting.")
+				// """ opens a multiline string
+				multiline := strings.HasPrefix(lex.input[lex.position:], `""`)
+				if multiline {
+					lex.position += len(`""`)
+				}
+				if !lex.emitString(multiline) {
+					return nil
+				}
+			case '`':
+				value, ok := lex.scanRawString()
+				if !ok {
 					lex.emit(token.ERR)
 					return nil
 				}
+				lex.emitValue(token.STRING, value)
 			case token.EoF:
 				lex.emit(token.EOF)
 				return nil
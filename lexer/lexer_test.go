@@ -10,14 +10,30 @@ import (
 
 func TestLexerNumbers(t *testing.T) {
 	var testCases = map[string]token.TokenType{
-		"11a":   token.ERR,
-		"11.a0": token.ERR,
-		"11a0":  token.ERR,
-		"11":    token.NUMBER,
-		"11.":   token.NUMBER,
-		".11":   token.NUMBER,
-		"1.0":   token.NUMBER,
-		"a11":   token.IDENTIFIER,
+		"11a":       token.ERR,
+		"11.a0":     token.ERR,
+		"11a0":      token.ERR,
+		"11":        token.NUMBER,
+		"11.":       token.NUMBER,
+		".11":       token.NUMBER,
+		"1.0":       token.NUMBER,
+		"4i":        token.NUMBER,
+		"1.5i":      token.NUMBER,
+		"4ix":       token.ERR,
+		"0xFF":      token.NUMBER,
+		"0o17":      token.NUMBER,
+		"0b1010":    token.NUMBER,
+		"1_000_000": token.NUMBER,
+		"0x_ff":     token.NUMBER,
+		"1_000.5":   token.NUMBER,
+		"0x":        token.ERR,
+		"0b102":     token.ERR,
+		"0o8":       token.ERR,
+		"0xFG":      token.ERR,
+		"1__000":    token.ERR,
+		"1000_":     token.ERR,
+		"1._5":      token.ERR,
+		"a11":       token.IDENTIFIER,
 	}
 	evaluateExpression1(t, testCases)
 }
@@ -61,8 +77,18 @@ func TestLexerExpression(t *testing.T) {
 		"if":                             []token.TokenType{token.IF},
 		"False == True":                  []token.TokenType{token.BOOL_FALSE, token.EQUAL_EQUAL, token.BOOL_TRUE},
 		"(False == True)":                []token.TokenType{token.OP, token.BOOL_FALSE, token.EQUAL_EQUAL, token.BOOL_TRUE, token.CP},
-		"decl b = 10; # (if equal True)": []token.TokenType{token.DECLARE, token.IDENTIFIER, token.EQUAL, token.NUMBER},
-		"decl a == 123":                  []token.TokenType{token.DECLARE, token.IDENTIFIER, token.EQUAL_EQUAL, token.NUMBER},
+		"decl b = 10; # (if equal True)": []token.TokenType{token.DECLARE, token.IDENTIFIER, token.EQUAL, token.NUMBER, token.SEMICOLON},
+		"for (decl i = 0; i < 3; i = i + 1)": []token.TokenType{token.FOR, token.OP, token.DECLARE, token.IDENTIFIER, token.EQUAL, token.NUMBER, token.SEMICOLON,
+			token.IDENTIFIER, token.LESS, token.NUMBER, token.SEMICOLON, token.IDENTIFIER, token.EQUAL, token.IDENTIFIER, token.PLUS, token.NUMBER, token.CP},
+		"for c in s":        []token.TokenType{token.FOR, token.IDENTIFIER, token.IN, token.IDENTIFIER},
+		"a[0] = [1, 2]":     []token.TokenType{token.IDENTIFIER, token.LSB, token.NUMBER, token.RSB, token.EQUAL, token.LSB, token.NUMBER, token.COMMA, token.NUMBER, token.RSB},
+		"this.x = p.y":      []token.TokenType{token.THIS, token.DOT, token.IDENTIFIER, token.EQUAL, token.IDENTIFIER, token.DOT, token.IDENTIFIER},
+		"a ** b // c % d":   []token.TokenType{token.IDENTIFIER, token.STAR_STAR, token.IDENTIFIER, token.SLASH_SLASH, token.IDENTIFIER, token.PERCENT, token.IDENTIFIER},
+		"a & b | c ^ ~d":    []token.TokenType{token.IDENTIFIER, token.AMPERSAND, token.IDENTIFIER, token.PIPE, token.IDENTIFIER, token.CARET, token.TILDE, token.IDENTIFIER},
+		"a << 1 >> b <= c":  []token.TokenType{token.IDENTIFIER, token.LESS_LESS, token.NUMBER, token.GREATER_GREATER, token.IDENTIFIER, token.LESS_EQUAL, token.IDENTIFIER},
+		"a ? b : c":         []token.TokenType{token.IDENTIFIER, token.QUESTION, token.IDENTIFIER, token.COLON, token.IDENTIFIER},
+		"\"a ${b} c ${d}\"": []token.TokenType{token.INTERPOLATION, token.IDENTIFIER, token.INTERPOLATION, token.IDENTIFIER, token.STRING},
+		"decl a == 123":     []token.TokenType{token.DECLARE, token.IDENTIFIER, token.EQUAL_EQUAL, token.NUMBER},
 	}
 	evaluateExpression(t, caseMap)
 }
@@ -78,6 +104,68 @@ func TestLexerString(t *testing.T) {
 	evaluateExpression1(t, caseMap)
 }
 
+func TestLexerStringValues(t *testing.T) {
+	var caseMap = map[string]string{
+		`"a\nb"`:                     "a\nb",
+		`"tab\there"`:                "tab\there",
+		`"say \"hi\""`:               `say "hi"`,
+		`"back\\slash"`:              `back\slash`,
+		`"caf\u00e9"`:                "café",
+		`"\U0001F600"`:               "\U0001F600",
+		`"é ü"`:                      "é ü",
+		"\"\"\"line1\nline2\"\"\"":   "line1\nline2",
+		"\"\"\"a \"quoted\" b\"\"\"": `a "quoted" b`,
+		"`raw\\n\nnext`":             "raw\\n\nnext",
+		`""`:                         "",
+	}
+	for input, expected := range caseMap {
+		lex := Init(input)
+		tkn, _ := lex.Consume()
+		if tkn.Type != token.STRING || tkn.Value != expected {
+			t.Errorf("input %s, output %s %q, expected %q", input, token.ReversedTokenMap[tkn.Type], tkn.Value, expected)
+		}
+	}
+
+	var errorCases = map[string]token.TokenType{
+		`"bad \q escape"`: token.ERR,
+		`"\u00g9"`:        token.ERR,
+		`"\u12"`:          token.ERR,
+		`"open`:           token.ERR,
+		"\"two\nlines\"":  token.ERR,
+		"\"\"\"open":      token.ERR,
+		"`open":           token.ERR,
+	}
+	evaluateExpression1(t, errorCases)
+}
+
+func TestLexerCharValues(t *testing.T) {
+	var caseMap = map[string]string{
+		`'a'`:      "a",
+		`'é'`:      "é",
+		`'\n'`:     "\n",
+		`'\''`:     "'",
+		`'"'`:      `"`,
+		`'\u00e9'`: "é",
+	}
+	for input, expected := range caseMap {
+		lex := Init(input)
+		tkn, _ := lex.Consume()
+		if tkn.Type != token.CHAR || tkn.Value != expected {
+			t.Errorf("input %s, output %s %q, expected %q", input, token.ReversedTokenMap[tkn.Type], tkn.Value, expected)
+		}
+	}
+
+	var errorCases = map[string]token.TokenType{
+		`''`:    token.ERR,
+		`'ab'`:  token.ERR,
+		`'a`:    token.ERR,
+		"'\n'":  token.ERR,
+		`'\q'`:  token.ERR,
+		`'\u1'`: token.ERR,
+	}
+	evaluateExpression1(t, errorCases)
+}
+
 func evaluateExpression(t *testing.T, caseMap map[string][]token.TokenType) {
 	for inputExp, expectExp := range caseMap {
 		lex := Init(inputExp)
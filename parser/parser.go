@@ -3,6 +3,7 @@ package parser
 import (
 	"fmt"
 	"os"
+	"unicode/utf8"
 
 	"github.com/badc0re/hprog/chunk"
 	"github.com/badc0re/hprog/codes"
@@ -17,26 +18,69 @@ const (
 	PREC_ILLEGAL PREC = iota
 	PREC_NONE
 	PREC_ASSIGN    // =
+	PREC_TERNARY   // ?:
 	PREC_OR        // or
 	PREC_AND       // and
 	PREC_EQUALLITY // ==, !=
 	PREC_COMPARE   // <, >, <=, >=
+	PREC_BIT_OR    // |
+	PREC_BIT_XOR   // ^
+	PREC_BIT_AND   // &
+	PREC_SHIFT     // <<, >>
 	PREC_TERM      // +, -
 	PREC_FACTOR    // *, /
-	PREC_UNARY     // !, -
+	PREC_UNARY     // !, -, ~
+	PREC_POWER     // **
 	PREC_CALL      // ., ()
 	PREC_PRIMARY
 )
 
+type FunctionType int
+
+const (
+	FT_ILLEGAL FunctionType = iota
+	FT_SCRIPT
+	FT_FUNCTION
+	FT_METHOD
+	FT_INITIALIZER
+)
+
 type Compiler struct {
+	Enclosing  *Compiler
+	Function   *value.ObjFunction
+	FType      FunctionType
 	Locals     []*Local
 	LocalCount int
+	Upvalues   []Upvalue
+	ScopeDepth int
+	Loop       *Loop
+}
+
+type Upvalue struct {
+	// slot of the enclosing local or index of its upvalue
+	Index   uint
+	IsLocal bool
+	Type    value.TypeTag
+}
+
+type ClassCompiler struct {
+	Enclosing     *ClassCompiler
+	HasSuperclass bool
+}
+
+type Loop struct {
+	Enclosing *Loop
+	// continue jumps back here
+	Start      uint
 	ScopeDepth int
+	BreakJumps []uint
 }
 
 type Local struct {
-	Name  token.Token
-	Depth int
+	Name       token.Token
+	Depth      int
+	IsCaptured bool
+	Type       value.TypeTag
 }
 
 type Parser struct {
@@ -47,50 +91,71 @@ type Parser struct {
 	ppanic      bool
 	tknMap      map[token.TokenType]ParseRule
 	currentComp *Compiler
-
-	// todo
-	chk *chunk.Chunk
+	// nil outside of a class body
+	currentClass *ClassCompiler
+	// annotated globals declared in this compile
+	globalTypes map[string]value.TypeTag
 }
 
 var tknMap = map[token.TokenType]ParseRule{
-	token.OP:            {Grouping, nil, PREC_CALL},
-	token.CP:            {nil, nil, PREC_NONE},
-	token.LB:            {nil, nil, PREC_NONE},
-	token.RB:            {nil, nil, PREC_NONE},
-	token.COMMA:         {nil, nil, PREC_NONE},
-	token.DOT:           {nil, nil, PREC_NONE},
-	token.MINUS:         {Unary, Binary, PREC_TERM},
-	token.PLUS:          {nil, Binary, PREC_TERM},
-	token.SEMICOLON:     {nil, nil, PREC_NONE},
-	token.SLASH:         {nil, Binary, PREC_FACTOR},
-	token.STAR:          {nil, Binary, PREC_FACTOR},
-	token.EXCL:          {Unary, nil, PREC_TERM},
-	token.EXCL_EQUAL:    {nil, Binary, PREC_EQUALLITY},
-	token.EQUAL:         {nil, nil, PREC_NONE},
-	token.EQUAL_EQUAL:   {nil, Binary, PREC_COMPARE},
-	token.GREATER:       {nil, Binary, PREC_COMPARE},
-	token.GREATER_EQUAL: {nil, Binary, PREC_COMPARE},
-	token.LESS:          {nil, Binary, PREC_COMPARE},
-	token.LESS_EQUAL:    {nil, Binary, PREC_COMPARE},
-	token.STRING:        {String, nil, PREC_NONE},
-	token.NUMBER:        {Number, nil, PREC_NONE},
-	token.AND:           {nil, nil, PREC_NONE},
-	token.ELSE:          {nil, nil, PREC_NONE},
-	token.BOOL_FALSE:    {Literal, nil, PREC_NONE},
-	token.BOOL_TRUE:     {Literal, nil, PREC_NONE},
-	token.FOR:           {nil, nil, PREC_NONE},
-	token.FUNCTION:      {nil, nil, PREC_NONE},
-	token.IF:            {nil, nil, PREC_NONE},
-	// maybe not
-	token.OR:         {nil, nil, PREC_NONE},
-	token.NIL:        {Literal, nil, PREC_NONE},
-	token.PRINT:      {nil, nil, PREC_NONE},
-	token.RETURN:     {nil, nil, PREC_NONE},
-	token.IDENTIFIER: {Variable, nil, PREC_NONE},
-	token.WHILE:      {nil, nil, PREC_NONE},
-	token.DECLARE:    {nil, nil, PREC_NONE},
-	token.ERR:        {nil, nil, PREC_NONE},
-	token.EOF:        {nil, nil, PREC_NONE},
+	token.OP:              {Grouping, Call, PREC_CALL},
+	token.CP:              {nil, nil, PREC_NONE},
+	token.LB:              {Map, nil, PREC_NONE},
+	token.RB:              {nil, nil, PREC_NONE},
+	token.LSB:             {Array, Index, PREC_CALL},
+	token.RSB:             {nil, nil, PREC_NONE},
+	token.COMMA:           {nil, nil, PREC_NONE},
+	token.COLON:           {nil, nil, PREC_NONE},
+	token.QUESTION:        {nil, Ternary, PREC_TERNARY},
+	token.DOT:             {nil, Dot, PREC_CALL},
+	token.MINUS:           {Unary, Binary, PREC_TERM},
+	token.PLUS:            {nil, Binary, PREC_TERM},
+	token.SEMICOLON:       {nil, nil, PREC_NONE},
+	token.SLASH:           {nil, Binary, PREC_FACTOR},
+	token.STAR:            {nil, Binary, PREC_FACTOR},
+	token.PERCENT:         {nil, Binary, PREC_FACTOR},
+	token.SLASH_SLASH:     {nil, Binary, PREC_FACTOR},
+	token.STAR_STAR:       {nil, Binary, PREC_POWER},
+	token.AMPERSAND:       {nil, Binary, PREC_BIT_AND},
+	token.PIPE:            {nil, Binary, PREC_BIT_OR},
+	token.CARET:           {nil, Binary, PREC_BIT_XOR},
+	token.TILDE:           {Unary, nil, PREC_NONE},
+	token.LESS_LESS:       {nil, Binary, PREC_SHIFT},
+	token.GREATER_GREATER: {nil, Binary, PREC_SHIFT},
+	token.EXCL:            {Unary, nil, PREC_TERM},
+	token.EXCL_EQUAL:      {nil, Binary, PREC_EQUALLITY},
+	token.EQUAL:           {nil, nil, PREC_NONE},
+	token.EQUAL_EQUAL:     {nil, Binary, PREC_COMPARE},
+	token.GREATER:         {nil, Binary, PREC_COMPARE},
+	token.GREATER_EQUAL:   {nil, Binary, PREC_COMPARE},
+	token.LESS:            {nil, Binary, PREC_COMPARE},
+	token.LESS_EQUAL:      {nil, Binary, PREC_COMPARE},
+	token.STRING:          {String, nil, PREC_NONE},
+	token.INTERPOLATION:   {Interpolation, nil, PREC_NONE},
+	token.NUMBER:          {Number, nil, PREC_NONE},
+	token.CHAR:            {Char, nil, PREC_NONE},
+	token.AND:             {nil, And, PREC_AND},
+	token.ELSE:            {nil, nil, PREC_NONE},
+	token.BOOL_FALSE:      {Literal, nil, PREC_NONE},
+	token.BOOL_TRUE:       {Literal, nil, PREC_NONE},
+	token.FOR:             {nil, nil, PREC_NONE},
+	token.FUNCTION:        {nil, nil, PREC_NONE},
+	token.IF:              {nil, nil, PREC_NONE},
+	token.OR:              {nil, Or, PREC_OR},
+	token.NIL:             {Literal, nil, PREC_NONE},
+	token.PRINT:           {nil, nil, PREC_NONE},
+	token.RETURN:          {nil, nil, PREC_NONE},
+	token.IDENTIFIER:      {Variable, nil, PREC_NONE},
+	token.THIS:            {This, nil, PREC_NONE},
+	token.SUPER:           {Super, nil, PREC_NONE},
+	token.CLASS:           {nil, nil, PREC_NONE},
+	token.WHILE:           {nil, nil, PREC_NONE},
+	token.IN:              {nil, nil, PREC_NONE},
+	token.BREAK:           {nil, nil, PREC_NONE},
+	token.CONTINUE:        {nil, nil, PREC_NONE},
+	token.DECLARE:         {nil, nil, PREC_NONE},
+	token.ERR:             {nil, nil, PREC_NONE},
+	token.EOF:             {nil, nil, PREC_NONE},
 }
 
 type ParseFn func(*Parser, bool)
@@ -102,16 +167,43 @@ type ParseRule struct {
 }
 
 func (p *Parser) emit(code interface{}) {
-	p.chk.WriteChunk(code, p.previous.Line)
+	p.currentChunk().WriteChunk(code, p.previous.Line)
 }
 
 func (p *Parser) emit2(code1 interface{}, code2 interface{}) {
-	p.chk.WriteChunk(code1, p.previous.Line)
-	p.chk.WriteChunk(code2, p.previous.Line)
+	p.currentChunk().WriteChunk(code1, p.previous.Line)
+	p.currentChunk().WriteChunk(code2, p.previous.Line)
+}
+
+func (p *Parser) currentChunk() *chunk.Chunk {
+	return p.currentComp.Function.Chunk.(*chunk.Chunk)
 }
 
-func (p *Parser) EndCompile() {
+func (p *Parser) EndCompile() *value.ObjFunction {
+	return p.endCompiler()
+}
+
+func (p *Parser) endCompiler() *value.ObjFunction {
 	p.emitReturn()
+	fn := p.currentComp.Function
+	p.currentComp = p.currentComp.Enclosing
+	return fn
+}
+
+func NewCompiler(enclosing *Compiler, fnType FunctionType, maxLocals int) *Compiler {
+	comp := Compiler{
+		Enclosing: enclosing,
+		FType:     fnType,
+		Function:  &value.ObjFunction{Chunk: &chunk.Chunk{}},
+		Locals:    make([]*Local, maxLocals),
+	}
+	// slot zero holds the called function, or the receiver
+	comp.Locals[0] = &Local{Depth: 0}
+	if fnType == FT_METHOD || fnType == FT_INITIALIZER {
+		comp.Locals[0].Name = token.Token{Value: "this"}
+	}
+	comp.LocalCount = 1
+	return &comp
 }
 
 func (p *Parser) getRule(tknType token.TokenType) ParseRule {
@@ -131,6 +223,14 @@ func (p *Parser) Consume(tknType token.TokenType, message string) {
 	p.reportError(p.current, message)
 }
 
+func (p *Parser) consumeSemi(message string) {
+	// the last statement of a block or file may omit it
+	if p.Check(token.RB) || p.Check(token.EOF) {
+		return
+	}
+	p.Consume(token.SEMICOLON, message)
+}
+
 func (p *Parser) parsePrec(prec PREC, assign bool) {
 	p.Advance()
 
@@ -155,7 +255,29 @@ func (p *Parser) parsePrec(prec PREC, assign bool) {
 	}
 }
 
+func (p *Parser) emitJump(code codes.INSTRUC) uint {
+	// placeholder, patched by patchJump
+	p.emit2(code, uint(0))
+	return p.currentChunk().Count - 1
+}
+
+func (p *Parser) emitLoop(loopStart uint) {
+	p.emit(codes.INSTRUC_LOOP)
+	// jump back over the operand as well
+	p.emit(p.currentChunk().Count - loopStart + 1)
+}
+
+func (p *Parser) patchJump(offset uint) {
+	p.currentChunk().PatchJump(offset)
+}
+
 func (p *Parser) emitReturn() {
+	if p.currentComp.FType == FT_INITIALIZER {
+		// init always returns the instance
+		p.emit2(codes.INSTRUC_GET_DECL_LOCAL, uint(0))
+	} else {
+		p.emit(codes.INSTRUC_NIL)
+	}
 	p.emit(codes.INSTRUC_RETURN)
 }
 
@@ -164,7 +286,7 @@ func (p *Parser) emitConst(v value.Value) {
 }
 
 func (p *Parser) makeConstant(v value.Value) uint {
-	return p.chk.AddVariable(v)
+	return p.currentChunk().AddVariable(v)
 }
 
 func (p *Parser) Expression(assign bool) {
@@ -183,6 +305,10 @@ func (p *Parser) Match(tokenType token.TokenType) bool {
 func (p *Parser) Decl() {
 	if p.Match(token.DECLARE) {
 		p.declVarStmt()
+	} else if p.Match(token.FUNCTION) {
+		p.fnDecl()
+	} else if p.Match(token.CLASS) {
+		p.classDecl()
 	} else {
 		p.Statement()
 	}
@@ -192,16 +318,170 @@ func (p *Parser) Decl() {
 }
 
 func (p *Parser) declVarStmt() {
-	index := p.parseVar("Expected variable Name.")
+	tag, index := p.parseTypedVar("Expected variable Name.")
+	name := p.previous.Value
+	// decl b[] defaults to an empty array
+	isArray := p.Match(token.LSB)
+	if isArray {
+		p.Consume(token.RSB, "Expected ']' after '['.")
+		// decl string b[], elements are not checked
+		if tag != value.TT_ANY {
+			tag = value.TT_ARRAY
+		}
+	}
+	check := tag
 	if p.Match(token.EQUAL) {
+		start := p.currentChunk().Count
 		p.Expression(true)
+		check = p.checkAssign(tag, start, name)
+	} else if isArray || tag == value.TT_ARRAY {
+		p.emit2(codes.INSTRUC_ARRAY, uint(0))
+		check = value.TT_ANY
+	} else if tag == value.TT_MAP {
+		p.emit2(codes.INSTRUC_MAP, uint(0))
+		check = value.TT_ANY
+	} else if tag != value.TT_ANY {
+		p.emitConst(value.ZeroValue(tag))
+		check = value.TT_ANY
 	} else {
 		p.emit(codes.INSTRUC_NIL)
 	}
-	p.Consume(token.SEMICOLON, "Malformed variable declaration.")
+	p.consumeSemi("Malformed variable declaration.")
+	p.defineTypedVar(index, tag, check)
+}
+
+func (p *Parser) classDecl() {
+	p.Consume(token.IDENTIFIER, "Expected class name.")
+	className := *p.previous
+	nameConst := p.identifierConst(p.previous)
+	p.declVar()
+
+	p.emit2(codes.INSTRUC_CLASS, nameConst)
+	p.defineDeclVar(nameConst)
+
+	p.currentClass = &ClassCompiler{Enclosing: p.currentClass}
+
+	// class B < A
+	if p.Match(token.LESS) {
+		p.Consume(token.IDENTIFIER, "Expected superclass name.")
+		if p.previous.Value == className.Value {
+			p.reportError(p.previous, "A class cannot inherit from itself.")
+		}
+		Variable(p, false)
+
+		// methods capture the superclass as 'super'
+		p.beginDeclScope()
+		p.addScopedVar(token.Token{Value: "super", Line: p.previous.Line})
+		p.markInitialized()
+
+		p.definedVar(&className, false)
+		p.emit(codes.INSTRUC_INHERIT)
+		p.currentClass.HasSuperclass = true
+	}
+
+	// class stays on the stack while methods are bound
+	p.definedVar(&className, false)
+	p.Consume(token.LB, "Expected '{' before class body.")
+	for !p.Check(token.RB) && !p.Check(token.EOF) {
+		p.method()
+	}
+	p.Consume(token.RB, "Expected '}' after class body.")
+	p.Match(token.SEMICOLON)
+	p.emit(codes.INSTRUC_POP)
+
+	if p.currentClass.HasSuperclass {
+		p.endDeclScope()
+	}
+	p.currentClass = p.currentClass.Enclosing
+}
+
+func (p *Parser) method() {
+	p.Consume(token.FUNCTION, "Expected 'fn' before method.")
+	p.returnType()
+	p.Consume(token.IDENTIFIER, "Expected method name.")
+	nameConst := p.identifierConst(p.previous)
+
+	fnType := FT_METHOD
+	if p.previous.Value == "init" {
+		fnType = FT_INITIALIZER
+	}
+	p.function(fnType)
+	p.emit2(codes.INSTRUC_METHOD, nameConst)
+}
+
+func (p *Parser) returnType() {
+	// return type, fn (bool) name(...), not enforced
+	if p.Match(token.OP) {
+		p.Consume(token.IDENTIFIER, "Expected return type.")
+		p.Consume(token.CP, "Expected ')' after return type.")
+	}
+}
+
+func (p *Parser) fnDecl() {
+	p.returnType()
+	index := p.parseVar("Expected function name.")
+	// a local function can call itself
+	if p.currentComp.ScopeDepth > 0 {
+		p.markInitialized()
+	}
+	p.function(FT_FUNCTION)
 	p.defineDeclVar(index)
 }
 
+func (p *Parser) function(fnType FunctionType) {
+	comp := NewCompiler(p.currentComp, fnType, len(p.currentComp.Locals))
+	comp.Function.Name = p.previous.Value
+	p.currentComp = comp
+	p.beginDeclScope()
+
+	p.Consume(token.OP, "Expected '(' after function name.")
+	if !p.Check(token.CP) {
+		for {
+			comp.Function.Arity++
+			if comp.Function.Arity > 255 {
+				p.reportError(p.current, "Too many parameters.")
+			}
+			tag, index := p.parseTypedVar("Expected parameter name.")
+			comp.Function.ParamTypes = append(comp.Function.ParamTypes, tag)
+			p.defineTypedVar(index, tag, value.TT_ANY)
+			if !p.Match(token.COMMA) {
+				break
+			}
+		}
+	}
+	p.Consume(token.CP, "Expected ')' after parameters.")
+
+	/*
+		fn name() { ... }
+		fn name() = { ... }
+		fn name() = return expression
+		fn name() = expression
+	*/
+	if p.Match(token.EQUAL) && !p.Check(token.LB) {
+		if p.Check(token.RETURN) {
+			p.Statement()
+		} else {
+			p.Expression(false)
+			p.consumeSemi("Malformed function body.")
+			p.emit(codes.INSTRUC_RETURN)
+		}
+	} else {
+		p.Consume(token.LB, "Expected '{' before function body.")
+		p.insideBlock()
+	}
+
+	// no endDeclScope, the frame is discarded on return
+	fn := p.endCompiler()
+	p.emit2(codes.INSTRUC_CLOSURE, p.makeConstant(value.NewFunction(fn)))
+	for _, upvalue := range comp.Upvalues {
+		isLocal := uint(0)
+		if upvalue.IsLocal {
+			isLocal = 1
+		}
+		p.emit2(isLocal, upvalue.Index)
+	}
+}
+
 func (p *Parser) declVar() {
 	if p.currentComp.ScopeDepth == 0 {
 		return
@@ -234,7 +514,24 @@ func (p *Parser) markInitialized() {
 
 func (p *Parser) parseVar(msg string) (index uint) {
 	p.Consume(token.IDENTIFIER, msg)
+	return p.declVarName()
+}
 
+// parseTypedVar parses a name with an optional type in front, decl int a.
+func (p *Parser) parseTypedVar(msg string) (value.TypeTag, uint) {
+	p.Consume(token.IDENTIFIER, msg)
+	tag := value.TT_ANY
+	if p.Check(token.IDENTIFIER) {
+		found := false
+		if tag, found = value.TypeNames[p.previous.Value]; !found {
+			p.reportError(p.previous, "Unknown type.")
+		}
+		p.Advance()
+	}
+	return tag, p.declVarName()
+}
+
+func (p *Parser) declVarName() (index uint) {
 	p.declVar()
 
 	if p.currentComp.ScopeDepth > 0 {
@@ -249,12 +546,75 @@ func (p *Parser) identifierConst(ptoken *token.Token) (index uint) {
 }
 
 func (p *Parser) defineDeclVar(index uint) {
+	p.defineTypedVar(index, value.TT_ANY, value.TT_ANY)
+}
+
+// defineTypedVar declares a variable annotated with tag, check is the
+// annotation a local's initial value still has to be verified against.
+func (p *Parser) defineTypedVar(index uint, tag value.TypeTag, check value.TypeTag) {
 	if p.currentComp.ScopeDepth > 0 {
+		slot := p.currentComp.LocalCount - 1
+		p.currentComp.Locals[slot].Type = tag
+		if check != value.TT_ANY {
+			// the initial value is the top of the stack and the local itself
+			p.emit(codes.INSTRUC_SET_DECL_LOCAL)
+			p.emit2(uint(slot), check)
+		}
 		p.markInitialized()
 		return
 	}
-	// skip instruc about global decl
-	p.emit2(codes.INSTRUC_DECL_GLOBAL, index)
+	if tag != value.TT_ANY {
+		p.globalTypes[*value.AsString(&p.currentChunk().Constants.Values[index])] = tag
+	}
+	// the vm keeps the annotation to check later assignments
+	p.emit(codes.INSTRUC_DECL_GLOBAL)
+	p.emit2(index, tag)
+}
+
+// staticType returns the type of the expression compiled since start,
+// known for single literals and annotated variables.
+func (p *Parser) staticType(start uint) (value.TypeTag, bool) {
+	chunk := p.currentChunk()
+	code := chunk.Code[start:chunk.Count]
+	switch {
+	case len(code) == 1 && (code[0] == codes.INSTRUC_TRUE || code[0] == codes.INSTRUC_FALSE):
+		return value.TT_BOOL, true
+	case len(code) == 2 && code[0] == codes.INSTRUC_ARRAY:
+		return value.TT_ARRAY, true
+	case len(code) == 2 && code[0] == codes.INSTRUC_MAP:
+		return value.TT_MAP, true
+	case len(code) == 2 && code[0] == codes.INSTRUC_GET_DECL_LOCAL:
+		tag := p.currentComp.Locals[code[1].(uint)].Type
+		return tag, tag != value.TT_ANY
+	case len(code) == 2 && code[0] == codes.INSTRUC_GET_DECL_GLOBAL:
+		name := chunk.Constants.Values[code[1].(uint)]
+		tag, found := p.globalTypes[*value.AsString(&name)]
+		return tag, found
+	case (len(code) == 2 || len(code) == 3 && code[2] == codes.INSTRUC_NEGATE) && code[0] == codes.INSTRUC_CONSTANT:
+		tag := value.TypeOf(&chunk.Constants.Values[code[1].(uint)])
+		return tag, tag != value.TT_ANY
+	}
+	return value.TT_ANY, false
+}
+
+// checkAssign reports an assignment of a known mismatching type, it
+// returns the annotation left to check at runtime.
+func (p *Parser) checkAssign(tag value.TypeTag, start uint, name string) value.TypeTag {
+	if tag == value.TT_ANY {
+		return tag
+	}
+	from, known := p.staticType(start)
+	if !known {
+		return tag
+	}
+	if !value.Assignable(tag, from) {
+		p.reportError(p.previous, fmt.Sprintf("Cannot assign %s to %s variable '%s'.", value.TTmap[from], value.TTmap[tag], name))
+	}
+	if from == tag {
+		return value.TT_ANY
+	}
+	// int to float still needs the conversion
+	return tag
 }
 
 func Unary(p *Parser, canAssign bool) {
@@ -267,6 +627,8 @@ func Unary(p *Parser, canAssign bool) {
 		p.emit(codes.INSTRUC_NEGATE)
 	case token.EXCL:
 		p.emit(codes.INSTRUC_NOT)
+	case token.TILDE:
+		p.emit(codes.INSTRUC_BIT_NOT)
 	default:
 		return
 	}
@@ -281,9 +643,9 @@ func Variable(p *Parser, canAssign bool) {
 	p.definedVar(p.previous, canAssign)
 }
 
-func (p *Parser) resolveLocal(ptoken *token.Token) (uint, bool) {
-	for i := p.currentComp.LocalCount - 1; i >= 0; i-- {
-		local := p.currentComp.Locals[i]
+func (p *Parser) resolveLocal(comp *Compiler, ptoken *token.Token) (uint, bool) {
+	for i := comp.LocalCount - 1; i >= 0; i-- {
+		local := comp.Locals[i]
 
 		if local == nil {
 			p.reportError(p.current, "Panic, local variable not defined.")
@@ -299,31 +661,75 @@ func (p *Parser) resolveLocal(ptoken *token.Token) (uint, bool) {
 	return 0, false
 }
 
-func (p *Parser) definedVar(ptoken *token.Token, canAssign bool) {
-	index, found := p.resolveLocal(ptoken)
+func (p *Parser) resolveUpvalue(comp *Compiler, ptoken *token.Token) (uint, bool) {
+	if comp.Enclosing == nil {
+		return 0, false
+	}
+	if local, found := p.resolveLocal(comp.Enclosing, ptoken); found {
+		comp.Enclosing.Locals[local].IsCaptured = true
+		return p.addUpvalue(comp, local, true, comp.Enclosing.Locals[local].Type), true
+	}
+	// captured further out, chain through the enclosing upvalue
+	if upvalue, found := p.resolveUpvalue(comp.Enclosing, ptoken); found {
+		return p.addUpvalue(comp, upvalue, false, comp.Enclosing.Upvalues[upvalue].Type), true
+	}
+	return 0, false
+}
+
+func (p *Parser) addUpvalue(comp *Compiler, index uint, isLocal bool, tag value.TypeTag) uint {
+	for i, upvalue := range comp.Upvalues {
+		if upvalue.Index == index && upvalue.IsLocal == isLocal {
+			return uint(i)
+		}
+	}
+	if len(comp.Upvalues) == len(comp.Locals) {
+		p.reportError(p.previous, "Too many closure variables.")
+		return 0
+	}
+	comp.Upvalues = append(comp.Upvalues, Upvalue{Index: index, IsLocal: isLocal, Type: tag})
+	comp.Function.UpvalueCount = len(comp.Upvalues)
+	return uint(len(comp.Upvalues) - 1)
+}
 
+func (p *Parser) definedVar(ptoken *token.Token, canAssign bool) {
 	getCode := codes.INSTRUC_GET_DECL_GLOBAL
 	setCode := codes.INSTRUC_SET_DECL_GLOBAL
 
+	// annotation of the variable, globals are also checked by the vm
+	tag := value.TT_ANY
+	index, found := p.resolveLocal(p.currentComp, ptoken)
 	if found {
 		getCode = codes.INSTRUC_GET_DECL_LOCAL
 		setCode = codes.INSTRUC_SET_DECL_LOCAL
+		tag = p.currentComp.Locals[index].Type
+	} else if index, found = p.resolveUpvalue(p.currentComp, ptoken); found {
+		getCode = codes.INSTRUC_GET_UPVALUE
+		setCode = codes.INSTRUC_SET_UPVALUE
+		tag = p.currentComp.Upvalues[index].Type
 	} else {
 		index = p.identifierConst(ptoken)
+		tag = p.globalTypes[ptoken.Value]
 	}
 
 	if canAssign && p.Match(token.EQUAL) {
 		/*
 			Needs to be a declared variable before
-			assigning.
+			assigning, checked by the SET instruction.
 		*/
-		p.emit2(getCode, index)
+		start := p.currentChunk().Count
 		p.Expression(canAssign)
+		check := p.checkAssign(tag, start, ptoken.Value)
 		/*
 			DECL_GLOBAL -> initial declaration
 			DECL_SET_GLOBAL -> assign on declared variable
 		*/
-		p.emit2(setCode, index)
+		if setCode == codes.INSTRUC_SET_DECL_GLOBAL {
+			p.emit2(setCode, index)
+		} else {
+			// locals carry their annotation, TT_ANY skips the check
+			p.emit(setCode)
+			p.emit2(index, check)
+		}
 	} else {
 		p.emit2(getCode, index)
 	}
@@ -333,12 +739,34 @@ func (p *Parser) Statement() {
 	/*
 		statement -> exprRessionStmt
 					| printStmt
+					| ifStmt
+					| whileStmt
+					| forStmt
+					| breakStmt
+					| continueStmt
+					| returnStmt
 					| block
 
 		block -> { delcare }
+		ifStmt -> if ( expression ) statement [ else statement ]
+		whileStmt -> while ( expression ) statement
+		forStmt -> for ( [init] ; [expression] ; [expression] ) statement
+				| for identifier in expression statement
 	*/
 	if p.Match(token.PRINT) {
 		p.PrintStmt()
+	} else if p.Match(token.IF) {
+		p.IfStmt()
+	} else if p.Match(token.WHILE) {
+		p.WhileStmt()
+	} else if p.Match(token.FOR) {
+		p.ForStmt()
+	} else if p.Match(token.BREAK) {
+		p.BreakStmt()
+	} else if p.Match(token.CONTINUE) {
+		p.ContinueStmt()
+	} else if p.Match(token.RETURN) {
+		p.ReturnStmt()
 	} else if p.Match(token.LB) {
 		p.beginDeclScope()
 		p.insideBlock()
@@ -357,22 +785,233 @@ func (p *Parser) insideBlock() {
 		p.Decl()
 	}
 	p.Consume(token.RB, "Missing '}' after expression.")
+	// newline after '}' ends the statement
+	p.Match(token.SEMICOLON)
 }
 
 func (p *Parser) endDeclScope() {
 	p.currentComp.ScopeDepth--
 
 	for p.currentComp.LocalCount > 0 && p.currentComp.Locals[p.currentComp.LocalCount-1].Depth > p.currentComp.ScopeDepth {
-		p.emit(codes.INSTRUC_POP)
+		p.emitPopLocal(p.currentComp.Locals[p.currentComp.LocalCount-1])
 		p.currentComp.LocalCount--
 	}
 }
 
+func (p *Parser) emitPopLocal(local *Local) {
+	if local.IsCaptured {
+		// move it to the heap before the slot is reused
+		p.emit(codes.INSTRUC_CLOSE_UPVALUE)
+	} else {
+		p.emit(codes.INSTRUC_POP)
+	}
+}
+
 func (p *Parser) ExpressionStmt() {
 	// variable has it
 	p.Expression(true)
-	p.Consume(token.SEMICOLON, "Malformed expression.")
+	p.consumeSemi("Malformed expression.")
+	p.emit(codes.INSTRUC_POP)
+}
+
+func (p *Parser) IfStmt() {
+	p.Consume(token.OP, "Expected '(' after 'if'.")
+	p.Expression(false)
+	p.Consume(token.CP, "Expected ')' after condition.")
+
+	thenJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	// condition is left on the stack by the jump
+	p.emit(codes.INSTRUC_POP)
+	p.Statement()
+
+	elseJump := p.emitJump(codes.INSTRUC_JUMP)
+	p.patchJump(thenJump)
+	p.emit(codes.INSTRUC_POP)
+
+	if p.Match(token.ELSE) {
+		p.Statement()
+	}
+	p.patchJump(elseJump)
+}
+
+func (p *Parser) WhileStmt() {
+	loopStart := p.currentChunk().Count
+
+	p.Consume(token.OP, "Expected '(' after 'while'.")
+	p.Expression(false)
+	p.Consume(token.CP, "Expected ')' after condition.")
+
+	exitJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	p.emit(codes.INSTRUC_POP)
+	// block locals are popped by endDeclScope before looping back
+	p.beginLoop(loopStart)
+	p.Statement()
+	p.emitLoop(loopStart)
+
+	p.patchJump(exitJump)
 	p.emit(codes.INSTRUC_POP)
+	p.endLoop()
+}
+
+func (p *Parser) ForStmt() {
+	// the loop variable is scoped to the loop
+	p.beginDeclScope()
+	if p.Match(token.OP) {
+		p.forClauses()
+	} else {
+		p.forIn()
+	}
+	p.endDeclScope()
+}
+
+func (p *Parser) forClauses() {
+	if p.Match(token.SEMICOLON) {
+		// no initializer
+	} else if p.Match(token.DECLARE) {
+		p.declVarStmt()
+	} else {
+		p.ExpressionStmt()
+	}
+
+	loopStart := p.currentChunk().Count
+	exitJump := -1
+	if !p.Match(token.SEMICOLON) {
+		p.Expression(false)
+		p.Consume(token.SEMICOLON, "Expected ';' after loop condition.")
+		exitJump = int(p.emitJump(codes.INSTRUC_JUMP_IF_FALSE))
+		p.emit(codes.INSTRUC_POP)
+	}
+
+	if !p.Match(token.CP) {
+		// increment runs after the body, jump over it for now
+		bodyJump := p.emitJump(codes.INSTRUC_JUMP)
+		incrementStart := p.currentChunk().Count
+		p.Expression(true)
+		p.emit(codes.INSTRUC_POP)
+		p.Consume(token.CP, "Expected ')' after for clauses.")
+
+		p.emitLoop(loopStart)
+		loopStart = incrementStart
+		p.patchJump(bodyJump)
+	}
+
+	p.beginLoop(loopStart)
+	p.Statement()
+	p.emitLoop(loopStart)
+
+	if exitJump != -1 {
+		p.patchJump(uint(exitJump))
+		p.emit(codes.INSTRUC_POP)
+	}
+	p.endLoop()
+}
+
+func (p *Parser) forIn() {
+	p.Consume(token.IDENTIFIER, "Expected loop variable after 'for'.")
+	name := *p.previous
+	p.Consume(token.IN, "Expected 'in' after loop variable.")
+
+	/*
+		hidden locals, names can't clash with identifiers:
+		[sequence] [cursor] [loop variable]
+	*/
+	seqSlot := uint(p.currentComp.LocalCount)
+	p.Expression(false)
+	p.addHiddenVar(" seq")
+	p.emitConst(value.NewInt(0))
+	p.addHiddenVar(" cursor")
+	p.emit(codes.INSTRUC_NIL)
+	p.addScopedVar(name)
+	p.markInitialized()
+
+	loopStart := p.currentChunk().Count
+	// pushes False once the sequence is exhausted
+	p.emit2(codes.INSTRUC_ITER_NEXT, seqSlot)
+	exitJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	p.emit(codes.INSTRUC_POP)
+
+	p.beginLoop(loopStart)
+	p.Statement()
+	p.emitLoop(loopStart)
+
+	p.patchJump(exitJump)
+	p.emit(codes.INSTRUC_POP)
+	p.endLoop()
+}
+
+func (p *Parser) beginLoop(start uint) {
+	p.currentComp.Loop = &Loop{
+		Enclosing:  p.currentComp.Loop,
+		Start:      start,
+		ScopeDepth: p.currentComp.ScopeDepth,
+	}
+}
+
+func (p *Parser) endLoop() {
+	loop := p.currentComp.Loop
+	// breaks land after the condition is popped
+	for _, offset := range loop.BreakJumps {
+		p.patchJump(offset)
+	}
+	p.currentComp.Loop = loop.Enclosing
+}
+
+func (p *Parser) popLoopLocals(loop *Loop) {
+	/*
+		locals stay declared for the compiler, only
+		the stack is cleaned up before jumping.
+	*/
+	for i := p.currentComp.LocalCount - 1; i >= 0; i-- {
+		if p.currentComp.Locals[i].Depth <= loop.ScopeDepth {
+			break
+		}
+		p.emitPopLocal(p.currentComp.Locals[i])
+	}
+}
+
+func (p *Parser) BreakStmt() {
+	loop := p.currentComp.Loop
+	if loop == nil {
+		p.reportError(p.previous, "Cannot use 'break' outside of a loop.")
+		return
+	}
+	p.consumeSemi("Malformed break statement.")
+	p.popLoopLocals(loop)
+	loop.BreakJumps = append(loop.BreakJumps, p.emitJump(codes.INSTRUC_JUMP))
+}
+
+func (p *Parser) ContinueStmt() {
+	loop := p.currentComp.Loop
+	if loop == nil {
+		p.reportError(p.previous, "Cannot use 'continue' outside of a loop.")
+		return
+	}
+	p.consumeSemi("Malformed continue statement.")
+	p.popLoopLocals(loop)
+	p.emitLoop(loop.Start)
+}
+
+func (p *Parser) addHiddenVar(name string) {
+	p.addScopedVar(token.Token{Value: name, Line: p.previous.Line})
+	p.markInitialized()
+}
+
+func (p *Parser) ReturnStmt() {
+	if p.currentComp.FType == FT_SCRIPT {
+		p.reportError(p.previous, "Cannot return from top-level code.")
+		return
+	}
+	if p.Match(token.SEMICOLON) || p.Check(token.RB) || p.Check(token.EOF) {
+		p.emitReturn()
+		return
+	}
+	if p.currentComp.FType == FT_INITIALIZER {
+		p.reportError(p.previous, "Cannot return a value from an initializer.")
+		return
+	}
+	p.Expression(false)
+	p.consumeSemi("Malformed return statement.")
+	p.emit(codes.INSTRUC_RETURN)
 }
 
 func (p *Parser) PrintStmt() {
@@ -384,7 +1023,7 @@ func (p *Parser) PrintStmt() {
 		// CP if only "print()"
 		p.emit(codes.INSTRUC_NIL)
 	}
-	p.Consume(token.SEMICOLON, "Malformed print statement.")
+	p.consumeSemi("Malformed print statement.")
 	p.emit(codes.INSTRUC_PRINT)
 }
 
@@ -409,7 +1048,12 @@ func (p *Parser) Advance() {
 func Binary(p *Parser, canAssign bool) {
 	tknType := p.previous.Type
 	rule := p.getRule(tknType)
-	p.parsePrec(rule.prec+1, canAssign)
+	if tknType == token.STAR_STAR {
+		// right-associative, 2 ** 3 ** 2 is 2 ** 9
+		p.parsePrec(rule.prec, canAssign)
+	} else {
+		p.parsePrec(rule.prec+1, canAssign)
+	}
 
 	switch tknType {
 	case token.PLUS:
@@ -420,6 +1064,22 @@ func Binary(p *Parser, canAssign bool) {
 		p.emit(codes.INSTRUC_MULTIPLY)
 	case token.SLASH:
 		p.emit(codes.INSTRUC_DIVIDE)
+	case token.PERCENT:
+		p.emit(codes.INSTRUC_MODULO)
+	case token.SLASH_SLASH:
+		p.emit(codes.INSTRUC_FLOOR_DIVIDE)
+	case token.STAR_STAR:
+		p.emit(codes.INSTRUC_POWER)
+	case token.AMPERSAND:
+		p.emit(codes.INSTRUC_BIT_AND)
+	case token.PIPE:
+		p.emit(codes.INSTRUC_BIT_OR)
+	case token.CARET:
+		p.emit(codes.INSTRUC_BIT_XOR)
+	case token.LESS_LESS:
+		p.emit(codes.INSTRUC_SHIFT_LEFT)
+	case token.GREATER_GREATER:
+		p.emit(codes.INSTRUC_SHIFT_RIGHT)
 	case token.EQUAL_EQUAL:
 		p.emit(codes.INSTRUC_EQUAL)
 	case token.EXCL_EQUAL:
@@ -437,8 +1097,171 @@ func Binary(p *Parser, canAssign bool) {
 	}
 }
 
+func Call(p *Parser, canAssign bool) {
+	argCount := p.argumentList()
+	p.emit2(codes.INSTRUC_CALL, argCount)
+}
+
+func (p *Parser) argumentList() uint {
+	argCount := uint(0)
+	if !p.Check(token.CP) {
+		for {
+			p.Expression(false)
+			if argCount == 255 {
+				p.reportError(p.previous, "Too many arguments.")
+			}
+			argCount++
+			if !p.Match(token.COMMA) {
+				break
+			}
+		}
+	}
+	p.Consume(token.CP, "Expected ')' after arguments.")
+	return argCount
+}
+
+func Dot(p *Parser, canAssign bool) {
+	p.Consume(token.IDENTIFIER, "Expected property name after '.'.")
+	name := p.identifierConst(p.previous)
+
+	if canAssign && p.Match(token.EQUAL) {
+		p.Expression(false)
+		p.emit2(codes.INSTRUC_SET_PROPERTY, name)
+	} else {
+		p.emit2(codes.INSTRUC_GET_PROPERTY, name)
+	}
+}
+
+func This(p *Parser, canAssign bool) {
+	if p.currentClass == nil {
+		p.reportError(p.previous, "Cannot use 'this' outside of a class.")
+		return
+	}
+	// resolves to slot zero of the method
+	p.definedVar(p.previous, false)
+}
+
+func Super(p *Parser, canAssign bool) {
+	if p.currentClass == nil {
+		p.reportError(p.previous, "Cannot use 'super' outside of a class.")
+		return
+	} else if !p.currentClass.HasSuperclass {
+		p.reportError(p.previous, "Cannot use 'super' in a class with no superclass.")
+		return
+	}
+	p.Consume(token.DOT, "Expected '.' after 'super'.")
+	p.Consume(token.IDENTIFIER, "Expected superclass method name.")
+	name := p.identifierConst(p.previous)
+
+	line := p.previous.Line
+	p.definedVar(&token.Token{Value: "this", Line: line}, false)
+	p.definedVar(&token.Token{Value: "super", Line: line}, false)
+	p.emit2(codes.INSTRUC_GET_SUPER, name)
+}
+
+func Array(p *Parser, canAssign bool) {
+	count := uint(0)
+	for !p.Check(token.RSB) {
+		p.Expression(false)
+		count++
+		if !p.Match(token.COMMA) {
+			// newline before the closing ']'
+			p.Match(token.SEMICOLON)
+			break
+		}
+	}
+	p.Consume(token.RSB, "Expected ']' after array elements.")
+	p.emit2(codes.INSTRUC_ARRAY, count)
+}
+
+func Map(p *Parser, canAssign bool) {
+	count := uint(0)
+	for !p.Check(token.RB) {
+		p.Expression(false)
+		p.Consume(token.COLON, "Expected ':' after map key.")
+		p.Expression(false)
+		count++
+		if !p.Match(token.COMMA) {
+			// newline before the closing '}'
+			p.Match(token.SEMICOLON)
+			break
+		}
+	}
+	p.Consume(token.RB, "Expected '}' after map entries.")
+	p.emit2(codes.INSTRUC_MAP, count)
+}
+
+func Index(p *Parser, canAssign bool) {
+	// s[a:b], a missing bound is nil
+	if p.Match(token.COLON) {
+		p.emit(codes.INSTRUC_NIL)
+		sliceEnd(p)
+		return
+	}
+	p.Expression(false)
+	if p.Match(token.COLON) {
+		sliceEnd(p)
+		return
+	}
+	p.Consume(token.RSB, "Expected ']' after index.")
+
+	if canAssign && p.Match(token.EQUAL) {
+		p.Expression(false)
+		p.emit(codes.INSTRUC_SET_INDEX)
+	} else {
+		p.emit(codes.INSTRUC_GET_INDEX)
+	}
+}
+
+func sliceEnd(p *Parser) {
+	if p.Check(token.RSB) {
+		p.emit(codes.INSTRUC_NIL)
+	} else {
+		p.Expression(false)
+	}
+	p.Consume(token.RSB, "Expected ']' after slice.")
+	p.emit(codes.INSTRUC_SLICE)
+}
+
+func And(p *Parser, canAssign bool) {
+	// left side is falsey, skip the right side and keep it
+	endJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	p.emit(codes.INSTRUC_POP)
+	p.parsePrec(PREC_AND, false)
+	p.patchJump(endJump)
+}
+
+func Ternary(p *Parser, canAssign bool) {
+	// cond ? a : b, only the chosen branch runs
+	elseJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	p.emit(codes.INSTRUC_POP)
+	p.parsePrec(PREC_TERNARY, false)
+	endJump := p.emitJump(codes.INSTRUC_JUMP)
+
+	p.patchJump(elseJump)
+	p.emit(codes.INSTRUC_POP)
+	p.Consume(token.COLON, "Expected ':' after the first branch of '?'.")
+	// right-associative, a ? b : c ? d : e
+	p.parsePrec(PREC_TERNARY, false)
+	p.patchJump(endJump)
+}
+
+func Or(p *Parser, canAssign bool) {
+	// left side is truthy, skip the right side and keep it
+	elseJump := p.emitJump(codes.INSTRUC_JUMP_IF_FALSE)
+	endJump := p.emitJump(codes.INSTRUC_JUMP)
+	p.patchJump(elseJump)
+	p.emit(codes.INSTRUC_POP)
+	p.parsePrec(PREC_OR, false)
+	p.patchJump(endJump)
+}
+
 func Number(p *Parser, canAssign bool) {
 	dt := value.DetectNumberTypeByConversion(p.previous.Value)
+	if dt == value.VT_NIL {
+		p.reportError(p.previous, "Number literal out of range.")
+		return
+	}
 	p.emitConst(value.New(p.previous.Value, dt))
 }
 
@@ -446,6 +1269,39 @@ func String(p *Parser, canAssign bool) {
 	p.emitConst(value.NewString(p.previous.Value))
 }
 
+func Char(p *Parser, canAssign bool) {
+	r, _ := utf8.DecodeRuneInString(p.previous.Value)
+	p.emitConst(value.NewChar(r))
+}
+
+func Interpolation(p *Parser, canAssign bool) {
+	// "a ${b} c" is "a " + b + " c" with b converted to a string
+	parts := 0
+	concat := func() {
+		if parts > 0 {
+			p.emit(codes.INSTRUC_ADDITION)
+		}
+		parts++
+	}
+	for {
+		if p.previous.Value != "" {
+			p.emitConst(value.NewString(p.previous.Value))
+			concat()
+		}
+		p.Expression(false)
+		p.emit(codes.INSTRUC_TO_STRING)
+		concat()
+		if !p.Match(token.INTERPOLATION) {
+			break
+		}
+	}
+	p.Consume(token.STRING, "Expected '}' after interpolated expression.")
+	if p.previous.Value != "" {
+		p.emitConst(value.NewString(p.previous.Value))
+		concat()
+	}
+}
+
 func Literal(p *Parser, canAssign bool) {
 	tokenType := p.previous.Type
 	switch tokenType {
@@ -478,13 +1334,13 @@ func (p *Parser) reportError(tkn *token.Token, what string) {
 	*/
 }
 
-func Init(lex *lexer.Lexer, chk *chunk.Chunk, comp *Compiler) *Parser {
+func Init(lex *lexer.Lexer, comp *Compiler) *Parser {
 	p := Parser{
 		lex: lex,
-		chk: chk,
 	}
 	p.tknMap = tknMap
 	p.currentComp = comp
+	p.globalTypes = make(map[string]value.TypeTag)
 	/*
 		Init MUST return a reference, otherwise
 		the functions would not point to the correct
@@ -13,9 +13,16 @@ const (
 	CP
 	LB
 	RB
+	LSB
+	RSB
 	PLUS
 	SLASH
 	STAR
+	PERCENT
+	AMPERSAND
+	PIPE
+	CARET
+	TILDE
 	COMMA
 	DOT
 	MINUS
@@ -25,6 +32,7 @@ const (
 	NEW_LINE
 
 	COLON
+	QUESTION
 
 	EQUAL
 
@@ -35,6 +43,10 @@ const (
 	LESS
 	LESS_EQUAL
 	EQUAL_EQUAL
+	STAR_STAR
+	SLASH_SLASH
+	LESS_LESS
+	GREATER_GREATER
 
 	// Literals
 	IDENTIFIER
@@ -45,6 +57,10 @@ const (
 
 	NIL
 	STRING
+	// string segment before ${, "a ${b}" lexes as INTERPOLATION IDENTIFIER STRING
+	INTERPOLATION
+	// 'a', the value holds the decoded character
+	CHAR
 
 	// Keywords
 	IF
@@ -59,6 +75,9 @@ const (
 	RETURN
 	VAR
 	WHILE
+	IN
+	BREAK
+	CONTINUE
 
 	ARGS
 
@@ -66,6 +85,8 @@ const (
 	OR
 
 	CLASS
+	THIS
+	SUPER
 
 	BOOL_FALSE
 	BOOL_TRUE
@@ -87,14 +108,22 @@ var TokenMap = map[string]TokenType{
 	")":  CP,
 	"{":  LB,
 	"}":  RB,
+	"[":  LSB,
+	"]":  RSB,
 	"+":  PLUS,
 	"/":  SLASH,
 	"*":  STAR,
+	"%":  PERCENT,
+	"&":  AMPERSAND,
+	"|":  PIPE,
+	"^":  CARET,
+	"~":  TILDE,
 	",":  COMMA,
 	".":  DOT,
 	"-":  MINUS,
 	";":  SEMICOLON,
 	":":  COLON,
+	"?":  QUESTION,
 	"\"": QUOTE,
 
 	">":  GREATER,
@@ -105,6 +134,10 @@ var TokenMap = map[string]TokenType{
 	"<=": LESS_EQUAL,
 	"=":  EQUAL,
 	"==": EQUAL_EQUAL,
+	"**": STAR_STAR,
+	"//": SLASH_SLASH,
+	"<<": LESS_LESS,
+	">>": GREATER_GREATER,
 
 	// Keywords
 	"_":      PLACEHOLDER,
@@ -115,12 +148,20 @@ var TokenMap = map[string]TokenType{
 
 	"for":   FOR,
 	"while": WHILE,
+	"in":    IN,
+
+	"break":    BREAK,
+	"continue": CONTINUE,
 
 	"args": ARGS,
 
 	"and": AND,
 	"or":  OR,
 
+	"class": CLASS,
+	"this":  THIS,
+	"super": SUPER,
+
 	"False": BOOL_FALSE,
 	"True":  BOOL_TRUE,
 
@@ -132,9 +173,11 @@ var TokenMap = map[string]TokenType{
 	"nil":    NIL,
 
 	// for dbg
-	"<STRING>":     STRING,
-	"<IDENTIFIER>": IDENTIFIER,
-	"<NUMBER>":     NUMBER,
+	"<STRING>":        STRING,
+	"<INTERPOLATION>": INTERPOLATION,
+	"<CHAR>":          CHAR,
+	"<IDENTIFIER>":    IDENTIFIER,
+	"<NUMBER>":        NUMBER,
 	// for debugging
 	"ERROR": ERR,
 	"\\0":   EOF,
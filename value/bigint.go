@@ -0,0 +1,107 @@
+package value
+
+import (
+	"math"
+	"math/big"
+)
+
+// maxBigIntBits bounds the ints built by << and **, larger results
+// would exhaust memory long before they are useful.
+const maxBigIntBits = 1 << 20
+
+// NewBigInt keeps b exact, values that fit an int are normalized back
+// to VT_INT so small results stay fast.
+func NewBigInt(b *big.Int) Value {
+	if b.IsInt64() {
+		return NewInt(int(b.Int64()))
+	}
+	return Value{
+		_V: V{_big: b},
+		VT: VT_BIGINT,
+	}
+}
+
+// toBig returns any integer as a big.Int, the result must not be
+// modified since VT_BIGINT values share theirs.
+func toBig(v *Value) *big.Int {
+	switch {
+	case v.VT == VT_BIGINT:
+		return v._V._big
+	case IsUnsigned(v.VT):
+		return new(big.Int).SetUint64(v._V._uint)
+	}
+	return big.NewInt(int64(v._V._int))
+}
+
+// lowBits is the two's complement of b truncated to 64 bits.
+func lowBits(b *big.Int) uint64 {
+	return new(big.Int).And(b, new(big.Int).SetUint64(math.MaxUint64)).Uint64()
+}
+
+func addInt(a int, b int) Value {
+	t := a + b
+	// both operands have the sign the sum lost
+	if (a^t)&(b^t) < 0 {
+		return NewBigInt(new(big.Int).Add(big.NewInt(int64(a)), big.NewInt(int64(b))))
+	}
+	return NewInt(t)
+}
+
+func subInt(a int, b int) Value {
+	t := a - b
+	if (a^b)&(a^t) < 0 {
+		return NewBigInt(new(big.Int).Sub(big.NewInt(int64(a)), big.NewInt(int64(b))))
+	}
+	return NewInt(t)
+}
+
+func mulInt(a int, b int) Value {
+	t := a * b
+	if a != 0 && (t/a != b || (a == -1 && b == math.MinInt)) {
+		return NewBigInt(new(big.Int).Mul(big.NewInt(int64(a)), big.NewInt(int64(b))))
+	}
+	return NewInt(t)
+}
+
+func divInt(a int, b int) Value {
+	// the only quotient out of range
+	if a == math.MinInt && b == -1 {
+		return NewBigInt(new(big.Int).Neg(big.NewInt(int64(a))))
+	}
+	return NewInt(a / b)
+}
+
+// floorDivModInt divides rounding toward negative infinity, the
+// remainder has the sign of b.
+func floorDivModInt(a int, b int) (Value, int) {
+	r := a % b
+	if r != 0 && (r < 0) != (b < 0) {
+		r += b
+		q := divInt(a, b)
+		return subInt(q._V._int, 1), r
+	}
+	return divInt(a, b), r
+}
+
+func floorDivModBig(a *big.Int, b *big.Int) (*big.Int, *big.Int) {
+	q, r := new(big.Int).QuoRem(a, b, new(big.Int))
+	if r.Sign() != 0 && r.Sign() != b.Sign() {
+		q.Sub(q, big.NewInt(1))
+		r.Add(r, b)
+	}
+	return q, r
+}
+
+// powWrap is exponentiation by squaring modulo 2^64, the low bits of
+// any sized result.
+func powWrap(base uint64, exp uint64) uint64 {
+	result := uint64(1)
+	for exp > 0 {
+		if exp&1 == 1 {
+			result *= base
+		}
+		base *= base
+		exp >>= 1
+	}
+	return result
+}
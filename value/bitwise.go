@@ -0,0 +1,109 @@
+package value
+
+import (
+	"errors"
+	"math/big"
+)
+
+var ErrNotInteger = errors.New("operands must be integers")
+var ErrNegativeShift = errors.New("negative shift count")
+var ErrShiftTooLarge = errors.New("shift count too large")
+
+// bitwise applies an operator of the same type operands a and b, big
+// integers use two's complement like Go.
+func bitwise(a *Value, b *Value, intOp func(x, y uint64) uint64, bigOp func(z, x, y *big.Int) *big.Int) (Value, error) {
+	switch {
+	case a.VT == VT_BIGINT:
+		return NewBigInt(bigOp(new(big.Int), a._V._big, b._V._big)), nil
+	case a.VT == VT_INT:
+		return NewInt(int(intOp(uint64(a._V._int), uint64(b._V._int)))), nil
+	case IsSignedSized(a.VT):
+		return NewSized(int(intOp(uint64(a._V._int), uint64(b._V._int))), a.VT), nil
+	case IsUnsigned(a.VT):
+		return NewUnsigned(intOp(a._V._uint, b._V._uint), a.VT), nil
+	}
+	return Value{}, ErrNotInteger
+}
+
+func BitAnd(a *Value, b *Value) (Value, error) {
+	return bitwise(a, b, func(x, y uint64) uint64 { return x & y }, (*big.Int).And)
+}
+
+func BitOr(a *Value, b *Value) (Value, error) {
+	return bitwise(a, b, func(x, y uint64) uint64 { return x | y }, (*big.Int).Or)
+}
+
+func BitXor(a *Value, b *Value) (Value, error) {
+	return bitwise(a, b, func(x, y uint64) uint64 { return x ^ y }, (*big.Int).Xor)
+}
+
+// BitNot flips every bit, ~x is -x - 1 for signed integers.
+func BitNot(a Value) (Value, error) {
+	switch {
+	case a.VT == VT_BIGINT:
+		return NewBigInt(new(big.Int).Not(a._V._big)), nil
+	case a.VT == VT_INT:
+		return NewInt(^a._V._int), nil
+	case IsSignedSized(a.VT):
+		return NewSized(^a._V._int, a.VT), nil
+	case IsUnsigned(a.VT):
+		return NewUnsigned(^a._V._uint, a.VT), nil
+	}
+	return Value{}, ErrNotInteger
+}
+
+// shiftCount reads the right operand of a shift, any integer type.
+func shiftCount(b *Value) (uint, error) {
+	if !IsIntegerType(b.VT) {
+		return 0, ErrNotInteger
+	}
+	if IsUnsigned(b.VT) {
+		return uint(b._V._uint), nil
+	}
+	n, fits := IntValue(b)
+	if !fits {
+		return 0, ErrShiftTooLarge
+	}
+	if n < 0 {
+		return 0, ErrNegativeShift
+	}
+	return uint(n), nil
+}
+
+// ShiftLeft is exact for ints, 1 << 64 is a big integer, up to
+// maxBigIntBits. Sized integers drop the bits shifted out.
+func ShiftLeft(a *Value, b *Value) (Value, error) {
+	n, err := shiftCount(b)
+	if err != nil {
+		return Value{}, err
+	}
+	switch {
+	case a.VT == VT_INT || a.VT == VT_BIGINT:
+		if n > maxBigIntBits {
+			return Value{}, ErrShiftTooLarge
+		}
+		return NewBigInt(new(big.Int).Lsh(toBig(a), n)), nil
+	case IsSignedSized(a.VT):
+		return NewSized(a._V._int<<n, a.VT), nil
+	case IsUnsigned(a.VT):
+		return NewUnsigned(a._V._uint<<n, a.VT), nil
+	}
+	return Value{}, ErrNotInteger
+}
+
+// ShiftRight is arithmetic for signed integers, -8 >> 1 is -4.
+func ShiftRight(a *Value, b *Value) (Value, error) {
+	n, err := shiftCount(b)
+	if err != nil {
+		return Value{}, err
+	}
+	switch {
+	case a.VT == VT_BIGINT:
+		return NewBigInt(new(big.Int).Rsh(a._V._big, n)), nil
+	case a.VT == VT_INT || IsSignedSized(a.VT):
+		return NewSized(a._V._int>>n, a.VT), nil
+	case IsUnsigned(a.VT):
+		return NewUnsigned(a._V._uint>>n, a.VT), nil
+	}
+	return Value{}, ErrNotInteger
+}
@@ -0,0 +1,165 @@
+package value
+
+import (
+	"math"
+	"math/big"
+)
+
+func IsIntegerType(v VALUE_TYPE) bool {
+	return v == VT_INT || v == VT_BIGINT || IsSignedSized(v) || IsUnsigned(v)
+}
+func IsSignedSized(v VALUE_TYPE) bool { return v >= VT_INT8 && v <= VT_INT64 }
+func IsUnsigned(v VALUE_TYPE) bool    { return v >= VT_UINT8 && v <= VT_UINT64 }
+func AsUint(v *Value) uint64          { return v._V._uint }
+
+// NewSized truncates value to the signed type vt, wrapping around
+// on overflow.
+func NewSized(value int, vt VALUE_TYPE) Value {
+	switch vt {
+	case VT_INT8:
+		value = int(int8(value))
+	case VT_INT16:
+		value = int(int16(value))
+	case VT_INT32:
+		value = int(int32(value))
+	case VT_INT64:
+		value = int(int64(value))
+	}
+	return Value{
+		_V: V{_int: value},
+		VT: vt,
+	}
+}
+
+// NewUnsigned truncates value to the unsigned type vt, wrapping
+// around on overflow.
+func NewUnsigned(value uint64, vt VALUE_TYPE) Value {
+	switch vt {
+	case VT_UINT8:
+		value = uint64(uint8(value))
+	case VT_UINT16:
+		value = uint64(uint16(value))
+	case VT_UINT32:
+		value = uint64(uint32(value))
+	}
+	return Value{
+		_V: V{_uint: value},
+		VT: vt,
+	}
+}
+
+// IntValue returns any integer as a Go int, false for other values
+// and unsigned values above math.MaxInt64.
+func IntValue(v *Value) (int, bool) {
+	switch {
+	case v.VT == VT_INT || IsSignedSized(v.VT):
+		return v._V._int, true
+	case v.VT == VT_BIGINT && v._V._big.IsInt64():
+		return int(v._V._big.Int64()), true
+	case IsUnsigned(v.VT) && v._V._uint <= math.MaxInt64:
+		return int(v._V._uint), true
+	}
+	return 0, false
+}
+
+// Wrap converts any number to the integer type vt with Go conversion
+// semantics, floats are truncated and out of range values wrap.
+func Wrap(a Value, vt VALUE_TYPE) Value {
+	switch {
+	case vt == VT_INT && !Fits(&a, VT_INT):
+		// int is unbounded, int(1e30) stays exact
+		return bigValue(a)
+	case a.VT == VT_BIGINT:
+		return Wrap(NewUnsigned(lowBits(a._V._big), VT_UINT64), vt)
+	case a.VT == VT_FLOAT && IsUnsigned(vt):
+		return NewUnsigned(uint64(a._V._f64), vt)
+	case a.VT == VT_FLOAT:
+		return NewSized(int(a._V._f64), vt)
+	case IsUnsigned(a.VT) && IsUnsigned(vt):
+		return NewUnsigned(a._V._uint, vt)
+	case IsUnsigned(a.VT):
+		return NewSized(int(a._V._uint), vt)
+	case IsUnsigned(vt):
+		return NewUnsigned(uint64(a._V._int), vt)
+	}
+	return NewSized(a._V._int, vt)
+}
+
+// Fits reports whether the number a is in range of the integer type vt.
+func Fits(a *Value, vt VALUE_TYPE) bool {
+	var min int64
+	var max uint64
+	switch vt {
+	case VT_INT8:
+		min, max = math.MinInt8, math.MaxInt8
+	case VT_INT16:
+		min, max = math.MinInt16, math.MaxInt16
+	case VT_INT32:
+		min, max = math.MinInt32, math.MaxInt32
+	case VT_INT, VT_INT64:
+		min, max = math.MinInt64, math.MaxInt64
+	case VT_UINT8:
+		max = math.MaxUint8
+	case VT_UINT16:
+		max = math.MaxUint16
+	case VT_UINT32:
+		max = math.MaxUint32
+	case VT_UINT64:
+		max = math.MaxUint64
+	}
+	switch {
+	case a.VT == VT_FLOAT:
+		return a._V._f64 >= float64(min) && a._V._f64 < float64(max)
+	case a.VT == VT_BIGINT:
+		return a._V._big.Cmp(big.NewInt(min)) >= 0 && a._V._big.Cmp(new(big.Int).SetUint64(max)) <= 0
+	case IsUnsigned(a.VT):
+		return a._V._uint <= max
+	}
+	i := int64(a._V._int)
+	return i >= min && (i < 0 || uint64(i) <= max)
+}
+
+// ConvertChecked converts a for an operation on type v, false when
+// an integer does not fit the sized type.
+func ConvertChecked(a Value, v VALUE_TYPE) (Value, bool) {
+	if a.VT == v {
+		return a, true
+	}
+	switch {
+	case v == VT_BIGINT && IsIntegerType(a.VT):
+		// an operand, not normalized back to VT_INT
+		return Value{_V: V{_big: toBig(&a)}, VT: VT_BIGINT}, true
+	case a.VT == VT_BIGINT && v == VT_FLOAT:
+		f, _ := new(big.Float).SetInt(a._V._big).Float64()
+		return NewFloat(f), true
+	case a.VT == VT_BIGINT && v == VT_COMPLEX:
+		f, _ := new(big.Float).SetInt(a._V._big).Float64()
+		return NewComplex(complex(f, 0)), true
+	case a.VT == VT_BIGINT && IsIntegerType(v):
+		if a._V._big.IsUint64() {
+			return ConvertChecked(NewUnsigned(a._V._big.Uint64(), VT_UINT64), v)
+		}
+		return Wrap(a, v), false
+	case v == VT_COMPLEX && IsRealType(a.VT):
+		f, _ := ConvertChecked(a, VT_FLOAT)
+		return NewComplex(complex(f._V._f64, 0)), true
+	case v == VT_FLOAT && IsUnsigned(a.VT):
+		return NewFloat(float64(a._V._uint)), true
+	case v == VT_FLOAT && IsIntegerType(a.VT):
+		return NewFloat(float64(a._V._int)), true
+	case v == VT_INT && a.VT == VT_FLOAT:
+		return NewInt(int(a._V._f64)), true
+	case IsIntegerType(v) && IsIntegerType(a.VT):
+		return Wrap(a, v), Fits(&a, v)
+	}
+	return a, false
+}
+
+// bigValue converts any real number to an unbounded int.
+func bigValue(a Value) Value {
+	if a.VT == VT_FLOAT {
+		b, _ := big.NewFloat(a._V._f64).Int(nil)
+		return NewBigInt(b)
+	}
+	return NewBigInt(new(big.Int).Set(toBig(&a)))
+}
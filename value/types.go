@@ -0,0 +1,184 @@
+package value
+
+// TypeTag is the static type of an annotated variable, decl int a.
+type TypeTag int
+
+const (
+	TT_ANY TypeTag = iota
+	TT_INT
+	TT_INT8
+	TT_INT16
+	TT_INT32
+	TT_INT64
+	TT_UINT8
+	TT_UINT16
+	TT_UINT32
+	TT_UINT64
+	TT_FLOAT
+	TT_COMPLEX
+	TT_CHAR
+	TT_BOOL
+	TT_STRING
+	TT_ARRAY
+	TT_MAP
+)
+
+var TTmap = map[TypeTag]string{
+	TT_ANY:     "any",
+	TT_INT:     "int",
+	TT_INT8:    "int8",
+	TT_INT16:   "int16",
+	TT_INT32:   "int32",
+	TT_INT64:   "int64",
+	TT_UINT8:   "uint8",
+	TT_UINT16:  "uint16",
+	TT_UINT32:  "uint32",
+	TT_UINT64:  "uint64",
+	TT_FLOAT:   "float",
+	TT_COMPLEX: "complex",
+	TT_CHAR:    "char",
+	TT_BOOL:    "bool",
+	TT_STRING:  "string",
+	TT_ARRAY:   "array",
+	TT_MAP:     "map",
+}
+
+// TypeNames maps annotation names to their tags.
+var TypeNames = map[string]TypeTag{}
+
+func init() {
+	for tag, name := range TTmap {
+		TypeNames[name] = tag
+	}
+	TypeNames["uint"] = TT_UINT64
+}
+
+// sizedTypes pairs the sized integer annotations with their values.
+var sizedTypes = map[TypeTag]VALUE_TYPE{
+	TT_INT8:   VT_INT8,
+	TT_INT16:  VT_INT16,
+	TT_INT32:  VT_INT32,
+	TT_INT64:  VT_INT64,
+	TT_UINT8:  VT_UINT8,
+	TT_UINT16: VT_UINT16,
+	TT_UINT32: VT_UINT32,
+	TT_UINT64: VT_UINT64,
+}
+
+// TypeOf returns the tag of a value, TT_ANY when no annotation matches it.
+func TypeOf(v *Value) TypeTag {
+	switch v.VT {
+	case VT_INT, VT_BIGINT:
+		return TT_INT
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64, VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		for tag, vt := range sizedTypes {
+			if vt == v.VT {
+				return tag
+			}
+		}
+	case VT_FLOAT:
+		return TT_FLOAT
+	case VT_COMPLEX:
+		return TT_COMPLEX
+	case VT_CHAR:
+		return TT_CHAR
+	case VT_BOOL:
+		return TT_BOOL
+	case VT_OBJ:
+		switch ObjType(v) {
+		case O_STRING:
+			return TT_STRING
+		case O_ARRAY:
+			return TT_ARRAY
+		case O_MAP:
+			return TT_MAP
+		}
+	}
+	return TT_ANY
+}
+
+// TypeName describes the type of a value in type errors.
+func TypeName(v *Value) string {
+	if tag := TypeOf(v); tag != TT_ANY {
+		return TTmap[tag]
+	}
+	if v.VT == VT_NIL {
+		return "nil"
+	}
+	if IsObj(v) {
+		switch ObjType(v) {
+		case O_CLASS:
+			return "class"
+		case O_INSTANCE:
+			return "instance"
+		}
+		return "function"
+	}
+	return "any"
+}
+
+// Assignable reports whether a value of type from can be stored in a
+// variable annotated with to. An int widens to a float and converts to
+// sized integers when in range, sized integers need a conversion.
+// Ints and floats widen to complex.
+func Assignable(to TypeTag, from TypeTag) bool {
+	if to == TT_ANY || to == from {
+		return true
+	}
+	if to == TT_COMPLEX {
+		return from == TT_INT || from == TT_FLOAT
+	}
+	_, sized := sizedTypes[to]
+	return from == TT_INT && (to == TT_FLOAT || sized)
+}
+
+// Coerce converts v for a variable annotated with tag, false when the
+// value does not fit the annotation.
+func Coerce(v Value, tag TypeTag) (Value, bool) {
+	if tag == TT_ANY {
+		return v, true
+	}
+	from := TypeOf(&v)
+	if from == TT_ANY || !Assignable(tag, from) {
+		return v, false
+	}
+	if tag == TT_FLOAT && from == TT_INT {
+		return ConvertChecked(v, VT_FLOAT)
+	}
+	if tag == TT_COMPLEX {
+		return ConvertChecked(v, VT_COMPLEX)
+	}
+	if vt, sized := sizedTypes[tag]; sized && from == TT_INT {
+		return ConvertChecked(v, vt)
+	}
+	return v, true
+}
+
+// OutOfRange reports whether Coerce failed on the value rather than
+// its type, decl int8 a = 300.
+func OutOfRange(v *Value, tag TypeTag) bool {
+	from := TypeOf(v)
+	return from != TT_ANY && Assignable(tag, from)
+}
+
+// ZeroValue is the initial value of an annotated variable without
+// initializer, arrays and maps are created by the caller.
+func ZeroValue(tag TypeTag) Value {
+	switch tag {
+	case TT_INT:
+		return NewInt(0)
+	case TT_INT8, TT_INT16, TT_INT32, TT_INT64, TT_UINT8, TT_UINT16, TT_UINT32, TT_UINT64:
+		return Wrap(NewInt(0), sizedTypes[tag])
+	case TT_FLOAT:
+		return NewFloat(0)
+	case TT_COMPLEX:
+		return NewComplex(0)
+	case TT_CHAR:
+		return NewChar(0)
+	case TT_BOOL:
+		return NewBool(false)
+	case TT_STRING:
+		return NewString("")
+	}
+	return NewNil()
+}
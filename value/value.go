@@ -3,8 +3,14 @@ package value
 // move to generics soon...
 
 import (
+	"errors"
 	"fmt"
+	"math"
+	"math/big"
+	"math/cmplx"
 	"strconv"
+	"strings"
+	"unicode/utf8"
 )
 
 type VALUE_TYPE int
@@ -17,7 +23,21 @@ const (
 
 	VT_FLOAT
 	VT_INT
+	// sized integers wrap around like Go
+	VT_INT8
+	VT_INT16
+	VT_INT32
+	VT_INT64
+	VT_UINT8
+	VT_UINT16
+	VT_UINT32
+	VT_UINT64
+	// VT_INT promotes on overflow
+	VT_BIGINT
 	VT_COMPLEX
+	// a character, 'a', holds its code point
+	VT_CHAR
+	// literal kind of 0x, 0o and 0b numbers, the values are VT_INT
 	VT_HEX
 
 	VT_OBJ
@@ -31,7 +51,17 @@ var VTmap = map[VALUE_TYPE]string{
 
 	VT_FLOAT:   "VT_FLOAT",
 	VT_INT:     "VT_INT",
+	VT_INT8:    "VT_INT8",
+	VT_INT16:   "VT_INT16",
+	VT_INT32:   "VT_INT32",
+	VT_INT64:   "VT_INT64",
+	VT_UINT8:   "VT_UINT8",
+	VT_UINT16:  "VT_UINT16",
+	VT_UINT32:  "VT_UINT32",
+	VT_UINT64:  "VT_UINT64",
+	VT_BIGINT:  "VT_BIGINT",
 	VT_COMPLEX: "VT_COMPLEX",
+	VT_CHAR:    "VT_CHAR",
 	VT_HEX:     "VT_HEX",
 	VT_OBJ:     "VT_OBJ",
 
@@ -43,19 +73,110 @@ type OType int
 const (
 	O_ILLEGAL OType = iota
 	O_STRING
+	O_FUNCTION
+	O_CLOSURE
+	O_NATIVE
+	O_ARRAY
+	O_MAP
+	O_CLASS
+	O_INSTANCE
+	O_BOUND_METHOD
 )
 
 type ObjCtr struct {
 	_obj  interface{}
 	otype OType
 	_next *ObjCtr
+	// set on the one string the VM keeps for its contents
+	interned bool
 }
 
 type ObjString string
 
+type ObjFunction struct {
+	Arity        int
+	UpvalueCount int
+	Name         string
+	// annotated parameter types, TT_ANY when untyped
+	ParamTypes []TypeTag
+	// *chunk.Chunk, chunk already imports value
+	Chunk interface{}
+}
+
+// NativeFn is a Go function callable from scripts.
+type NativeFn func(args []Value) (Value, error)
+
+type ObjNative struct {
+	Name string
+	// negative arity accepts any number of arguments
+	Arity int
+	Fn    NativeFn
+}
+
+type ObjArray struct {
+	Elements []Value
+}
+
+type ObjMap struct {
+	// insertion order, used for printing and iteration
+	Order   []MapKey
+	Entries map[MapKey]MapEntry
+}
+
+type MapEntry struct {
+	Key   Value
+	Value Value
+}
+
+// MapKey is the hashable form of a Value, keys that are
+// Equal hash to the same MapKey.
+type MapKey struct {
+	vt    VALUE_TYPE
+	_bool bool
+	_int  int
+	_f64  float64
+	_c128 complex128
+	_str  string
+}
+
+type ObjClass struct {
+	Name    string
+	Methods map[string]Value
+}
+
+type ObjInstance struct {
+	Class  *ObjClass
+	Fields map[string]Value
+}
+
+// ObjBoundMethod keeps the receiver of p.move so the
+// method can be passed around.
+type ObjBoundMethod struct {
+	Receiver Value
+	Method   *ObjClosure
+}
+
+type ObjClosure struct {
+	Function *ObjFunction
+	Upvalues []*ObjUpvalue
+}
+
+// ObjUpvalue points at a stack slot while the variable is
+// alive and at Closed once the slot is popped.
+type ObjUpvalue struct {
+	Location *Value
+	Closed   Value
+	Slot     int
+	Next     *ObjUpvalue
+}
+
 type V struct {
-	_bool   bool
+	_bool bool
+	// also holds the signed sized integers
 	_int    int
+	_uint   uint64
+	_c128   complex128
+	_big    *big.Int
 	_f64    float64
 	_nil    bool
 	_objCtr *ObjCtr
@@ -70,24 +191,74 @@ type Value struct {
 }
 
 func PrintValue(v Value) {
+	vts := FormatValue(v)
+	if len(vts) > 0 {
+		fmt.Printf("%s (%s)", vts, VTmap[v.VT])
+	}
+}
+
+func FormatValue(v Value) string {
 	vts := ""
 	switch v.VT {
-	case VT_INT:
+	case VT_INT, VT_INT8, VT_INT16, VT_INT32, VT_INT64:
 		vts = strconv.Itoa(v._V._int)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		vts = strconv.FormatUint(v._V._uint, 10)
+	case VT_BIGINT:
+		vts = v._V._big.String()
 	case VT_FLOAT:
 		vts = strconv.FormatFloat(v._V._f64, 'E', -1, 64)
+	case VT_COMPLEX:
+		vts = strconv.FormatComplex(v._V._c128, 'g', -1, 128)
+	case VT_CHAR:
+		vts = string(rune(v._V._int))
 	case VT_BOOL:
 		vts = strconv.FormatBool(v._V._bool)
 	case VT_OBJ:
 		if IsString(&v) {
 			vts = *AsString(&v)
+		} else if IsFunction(&v) {
+			vts = "<fn " + AsFunction(&v).Name + ">"
+		} else if IsClosure(&v) {
+			vts = "<fn " + AsClosure(&v).Function.Name + ">"
+		} else if IsNative(&v) {
+			vts = "<native " + AsNative(&v).Name + ">"
+		} else if IsArray(&v) {
+			elements := make([]string, 0, len(AsArray(&v).Elements))
+			for _, e := range AsArray(&v).Elements {
+				elements = append(elements, formatElement(e))
+			}
+			vts = "[" + strings.Join(elements, ", ") + "]"
+		} else if IsMap(&v) {
+			m := AsMap(&v)
+			entries := make([]string, 0, len(m.Order))
+			for _, k := range m.Order {
+				entry := m.Entries[k]
+				entries = append(entries, formatElement(entry.Key)+": "+formatElement(entry.Value))
+			}
+			vts = "{" + strings.Join(entries, ", ") + "}"
+		} else if IsClass(&v) {
+			vts = "<class " + AsClass(&v).Name + ">"
+		} else if IsInstance(&v) {
+			vts = "<" + AsInstance(&v).Class.Name + " instance>"
+		} else if IsBoundMethod(&v) {
+			vts = "<fn " + AsBoundMethod(&v).Method.Function.Name + ">"
 		}
 	case VT_NIL:
 		vts = "nil"
 	}
-	if len(vts) > 0 {
-		fmt.Printf("%s (%s)", vts, VTmap[v.VT])
+	return vts
+}
+
+func formatElement(v Value) string {
+	// quoted so ["1"] and [1] print differently
+	if IsObj(&v) && IsString(&v) {
+		return strconv.Quote(*AsString(&v))
 	}
+	if v.VT == VT_CHAR {
+		return strconv.QuoteRune(rune(v._V._int))
+	}
+	return FormatValue(v)
 }
 
 func NewBool(value bool) Value {
@@ -97,6 +268,13 @@ func NewBool(value bool) Value {
 	}
 }
 
+func NewNil() Value {
+	return Value{
+		_V: V{_nil: true},
+		VT: VT_NIL,
+	}
+}
+
 func NewInt(value int) Value {
 	return Value{
 		_V: V{_int: value},
@@ -111,24 +289,47 @@ func NewFloat(value float64) Value {
 	}
 }
 
+func NewChar(value rune) Value {
+	return Value{
+		_V: V{_int: int(value)},
+		VT: VT_CHAR,
+	}
+}
+
+func NewComplex(value complex128) Value {
+	return Value{
+		_V: V{_c128: value},
+		VT: VT_COMPLEX,
+	}
+}
+
 func New(rawValue string, vt VALUE_TYPE) Value {
 	switch vt {
+	case VT_BIGINT:
+		b, _ := new(big.Int).SetString(strings.ReplaceAll(rawValue, "_", ""), 10)
+		return NewBigInt(b)
 	case VT_INT:
-		b, _ := strconv.Atoi(rawValue)
-		// int32
+		b, _ := strconv.Atoi(strings.ReplaceAll(rawValue, "_", ""))
+		// int64, sized integers come from annotations and conversions
 		return Value{
 			_V: V{_int: b},
 			VT: VT_INT,
 		}
 	case VT_FLOAT:
 		// float64
-		b, _ := strconv.ParseFloat(rawValue, 64)
+		b, _ := strconv.ParseFloat(strings.ReplaceAll(rawValue, "_", ""), 64)
 		return Value{
 			_V: V{_f64: b},
 			VT: VT_FLOAT,
 		}
-	//case VT_COMPLEX:
-	//case VT_HEX:
+	case VT_COMPLEX:
+		// imaginary literal, 4i
+		b, _ := strconv.ParseFloat(strings.ReplaceAll(strings.TrimSuffix(rawValue, "i"), "_", ""), 64)
+		return NewComplex(complex(0, b))
+	case VT_HEX:
+		// base 0 reads the prefix and the '_' separators
+		b, _ := new(big.Int).SetString(rawValue, 0)
+		return NewBigInt(b)
 	default:
 		return Value{
 			_V: V{_nil: true},
@@ -146,11 +347,15 @@ func Add(a *Value, b *Value) Value {
 			VT: VT_FLOAT,
 		}
 	case VT_INT:
-		t := a._V._int + b._V._int
-		return Value{
-			_V: V{_int: t},
-			VT: VT_INT,
-		}
+		return addInt(a._V._int, b._V._int)
+	case VT_BIGINT:
+		return NewBigInt(new(big.Int).Add(a._V._big, b._V._big))
+	case VT_COMPLEX:
+		return NewComplex(a._V._c128 + b._V._c128)
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		return NewSized(a._V._int+b._V._int, a.VT)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint+b._V._uint, a.VT)
 	case VT_OBJ:
 		t := ConvertToString(a) + ConvertToString(b)
 		return NewString(t)
@@ -168,33 +373,151 @@ func Sub(a *Value, b *Value) Value {
 			VT: VT_FLOAT,
 		}
 	case VT_INT:
-		t := a._V._int - b._V._int
-		return Value{
-			_V: V{_int: t},
-			VT: VT_INT,
-		}
+		return subInt(a._V._int, b._V._int)
+	case VT_BIGINT:
+		return NewBigInt(new(big.Int).Sub(a._V._big, b._V._big))
+	case VT_COMPLEX:
+		return NewComplex(a._V._c128 - b._V._c128)
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		return NewSized(a._V._int-b._V._int, a.VT)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint-b._V._uint, a.VT)
 	}
 	// TODO: return error!
 	return Value{}
 }
 
-func Divide(a *Value, b *Value) Value {
+var ErrDivisionByZero = errors.New("division by zero")
+var ErrNotReal = errors.New("operands must be real numbers")
+var ErrNegativeExponent = errors.New("negative exponent of a sized integer")
+var ErrResultTooLarge = errors.New("result too large")
+
+func Divide(a *Value, b *Value) (Value, error) {
+	if IsZero(b) {
+		return Value{}, ErrDivisionByZero
+	}
 	switch a.VT {
 	case VT_FLOAT:
 		t := a._V._f64 / b._V._f64
 		return Value{
 			_V: V{_f64: t},
 			VT: VT_FLOAT,
+		}, nil
+	case VT_INT:
+		return divInt(a._V._int, b._V._int), nil
+	case VT_BIGINT:
+		return NewBigInt(new(big.Int).Quo(a._V._big, b._V._big)), nil
+	case VT_COMPLEX:
+		return NewComplex(a._V._c128 / b._V._c128), nil
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		return NewSized(a._V._int/b._V._int, a.VT), nil
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint/b._V._uint, a.VT), nil
+	}
+	return Value{}, ErrNotReal
+}
+
+// FloorDivide rounds the quotient toward negative infinity, -7 // 2 is -4.
+func FloorDivide(a *Value, b *Value) (Value, error) {
+	if IsZero(b) {
+		return Value{}, ErrDivisionByZero
+	}
+	switch a.VT {
+	case VT_FLOAT:
+		return NewFloat(math.Floor(a._V._f64 / b._V._f64)), nil
+	case VT_INT:
+		q, _ := floorDivModInt(a._V._int, b._V._int)
+		return q, nil
+	case VT_BIGINT:
+		q, _ := floorDivModBig(a._V._big, b._V._big)
+		return NewBigInt(q), nil
+	case VT_COMPLEX:
+		return Value{}, ErrNotReal
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		q, _ := floorDivModInt(a._V._int, b._V._int)
+		return Wrap(q, a.VT), nil
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint/b._V._uint, a.VT), nil
+	}
+	return Value{}, ErrNotReal
+}
+
+// Modulo takes the sign of the divisor so that
+// a == (a // b) * b + a % b, -7 % 2 is 1.
+func Modulo(a *Value, b *Value) (Value, error) {
+	if IsZero(b) {
+		return Value{}, ErrDivisionByZero
+	}
+	switch a.VT {
+	case VT_FLOAT:
+		r := math.Mod(a._V._f64, b._V._f64)
+		if r != 0 && (r < 0) != (b._V._f64 < 0) {
+			r += b._V._f64
 		}
+		return NewFloat(r), nil
 	case VT_INT:
-		t := a._V._int / b._V._int
-		return Value{
-			_V: V{_int: t},
-			VT: VT_INT,
+		_, r := floorDivModInt(a._V._int, b._V._int)
+		return NewInt(r), nil
+	case VT_BIGINT:
+		_, r := floorDivModBig(a._V._big, b._V._big)
+		return NewBigInt(r), nil
+	case VT_COMPLEX:
+		return Value{}, ErrNotReal
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		_, r := floorDivModInt(a._V._int, b._V._int)
+		return NewSized(r, a.VT), nil
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint%b._V._uint, a.VT), nil
+	}
+	return Value{}, ErrNotReal
+}
+
+// Power is exact for ints up to maxBigIntBits, a negative exponent
+// gives a float. Sized integers wrap around like repeated multiplication.
+func Power(a *Value, b *Value) (Value, error) {
+	switch a.VT {
+	case VT_FLOAT:
+		return NewFloat(math.Pow(a._V._f64, b._V._f64)), nil
+	case VT_INT, VT_BIGINT:
+		base, exp := toBig(a), toBig(b)
+		if exp.Sign() < 0 {
+			fa, _ := ConvertChecked(*a, VT_FLOAT)
+			fb, _ := ConvertChecked(*b, VT_FLOAT)
+			return NewFloat(math.Pow(fa._V._f64, fb._V._f64)), nil
+		}
+		// at least exp * (bits - 1) bits, 0, 1 and -1 stay small
+		if bits := int64(base.BitLen() - 1); bits > 0 && (!exp.IsInt64() || exp.Int64() > maxBigIntBits/bits) {
+			return Value{}, ErrResultTooLarge
 		}
+		return NewBigInt(new(big.Int).Exp(base, exp, nil)), nil
+	case VT_COMPLEX:
+		return NewComplex(cmplx.Pow(a._V._c128, b._V._c128)), nil
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		if b._V._int < 0 {
+			return Value{}, ErrNegativeExponent
+		}
+		return NewSized(int(powWrap(uint64(a._V._int), uint64(b._V._int))), a.VT), nil
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(powWrap(a._V._uint, b._V._uint), a.VT), nil
 	}
-	// TODO: return error!
-	return Value{}
+	return Value{}, ErrNotReal
+}
+
+// IsZero reports a numeric zero, the divisor check of Divide.
+func IsZero(v *Value) bool {
+	switch {
+	case v.VT == VT_FLOAT:
+		return v._V._f64 == 0
+	case v.VT == VT_COMPLEX:
+		return v._V._c128 == 0
+	case v.VT == VT_BIGINT:
+		return v._V._big.Sign() == 0
+	case IsUnsigned(v.VT):
+		return v._V._uint == 0
+	case IsIntegerType(v.VT):
+		return v._V._int == 0
+	}
+	return false
 }
 
 func Multiply(a *Value, b *Value) Value {
@@ -206,11 +529,15 @@ func Multiply(a *Value, b *Value) Value {
 			VT: VT_FLOAT,
 		}
 	case VT_INT:
-		t := a._V._int * b._V._int
-		return Value{
-			_V: V{_int: t},
-			VT: VT_INT,
-		}
+		return mulInt(a._V._int, b._V._int)
+	case VT_BIGINT:
+		return NewBigInt(new(big.Int).Mul(a._V._big, b._V._big))
+	case VT_COMPLEX:
+		return NewComplex(a._V._c128 * b._V._c128)
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		return NewSized(a._V._int*b._V._int, a.VT)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(a._V._uint*b._V._uint, a.VT)
 	}
 	// TODO: return error!
 	return Value{}
@@ -222,14 +549,18 @@ func Negate(a Value) Value {
 		t := -a._V._f64
 		return Value{
 			_V: V{_f64: t},
-			VT: VT_NUMBER,
+			VT: VT_FLOAT,
 		}
 	case VT_INT:
-		t := -a._V._int
-		return Value{
-			_V: V{_int: t},
-			VT: VT_INT,
-		}
+		return subInt(0, a._V._int)
+	case VT_BIGINT:
+		return NewBigInt(new(big.Int).Neg(a._V._big))
+	case VT_COMPLEX:
+		return NewComplex(-a._V._c128)
+	case VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		return NewSized(-a._V._int, a.VT)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewUnsigned(-a._V._uint, a.VT)
 	case VT_BOOL:
 		t := !a._V._bool
 		return Value{
@@ -242,8 +573,21 @@ func Negate(a Value) Value {
 }
 
 func DetectNumberTypeByConversion(v string) VALUE_TYPE {
+	if len(v) > 1 && v[0] == '0' && strings.ContainsAny(v[1:2], "xXoObB") {
+		if _, ok := new(big.Int).SetString(v, 0); ok {
+			return VT_HEX
+		}
+		return VT_NIL
+	}
+	if strings.HasSuffix(v, "i") {
+		return VT_COMPLEX
+	}
+	v = strings.ReplaceAll(v, "_", "")
 	if _, err := strconv.Atoi(v); err == nil {
 		return VT_INT
+	} else if errors.Is(err, strconv.ErrRange) && strings.Trim(v, "0123456789") == "" {
+		// Atoi stops at the overflow, digits followed by '.' are a float
+		return VT_BIGINT
 	}
 	if _, err := strconv.ParseFloat(v, 64); err == nil {
 		return VT_FLOAT
@@ -260,12 +604,25 @@ func Equal(a *Value, b *Value) Value {
 		return NewBool(true)
 	case VT_BOOL:
 		return NewBool(a._V._bool == b._V._bool)
-	case VT_INT:
+	case VT_INT, VT_INT8, VT_INT16, VT_INT32, VT_INT64, VT_CHAR:
 		return NewBool(a._V._int == b._V._int)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewBool(a._V._uint == b._V._uint)
 	case VT_FLOAT:
 		return NewBool(a._V._f64 == b._V._f64)
+	case VT_COMPLEX:
+		return NewBool(a._V._c128 == b._V._c128)
+	case VT_BIGINT:
+		return NewBool(a._V._big.Cmp(b._V._big) == 0)
 	case VT_OBJ:
-		return NewBool(ConvertToString(a) == ConvertToString(b))
+		if IsString(a) && IsString(b) {
+			// interned strings are unique per contents
+			if IsInterned(a) && IsInterned(b) {
+				return NewBool(AsObj(a) == AsObj(b))
+			}
+			return NewBool(ConvertToString(a) == ConvertToString(b))
+		}
+		return NewBool(AsObj(a) == AsObj(b))
 	default:
 		return NewBool(false)
 	}
@@ -278,10 +635,14 @@ func Less(a *Value, b *Value) Value {
 	switch a.VT {
 	case VT_NIL:
 		return NewBool(false)
-	case VT_INT:
+	case VT_INT, VT_INT8, VT_INT16, VT_INT32, VT_INT64, VT_CHAR:
 		return NewBool(a._V._int < b._V._int)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewBool(a._V._uint < b._V._uint)
 	case VT_FLOAT:
 		return NewBool(a._V._f64 < b._V._f64)
+	case VT_BIGINT:
+		return NewBool(a._V._big.Cmp(b._V._big) < 0)
 	default:
 		return NewBool(false)
 	}
@@ -294,31 +655,21 @@ func Greater(a *Value, b *Value) Value {
 	switch a.VT {
 	case VT_NIL:
 		return NewBool(false)
-	case VT_INT:
+	case VT_INT, VT_INT8, VT_INT16, VT_INT32, VT_INT64, VT_CHAR:
 		return NewBool(a._V._int > b._V._int)
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		return NewBool(a._V._uint > b._V._uint)
 	case VT_FLOAT:
 		return NewBool(a._V._f64 > b._V._f64)
+	case VT_BIGINT:
+		return NewBool(a._V._big.Cmp(b._V._big) > 0)
 	default:
 		return NewBool(false)
 	}
 }
 
 func ConvertToExpectedType1(a Value, v VALUE_TYPE) Value {
-	_a := a
-	if _a.VT != v {
-		switch v {
-		case VT_INT:
-			_a = Value{
-				_V: V{_int: int(a._V._f64)},
-				VT: v,
-			}
-		case VT_FLOAT:
-			_a = Value{
-				_V: V{_f64: float64(a._V._int)},
-				VT: v,
-			}
-		}
-	}
+	_a, _ := ConvertChecked(a, v)
 	return _a
 }
 
@@ -339,23 +690,253 @@ func NewString(v string) Value {
 	}
 }
 
+func NewFunction(fn *ObjFunction) Value {
+	o := ObjCtr{
+		_obj:  fn,
+		otype: O_FUNCTION,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewClosure(fn *ObjFunction) Value {
+	o := ObjCtr{
+		_obj: &ObjClosure{
+			Function: fn,
+			Upvalues: make([]*ObjUpvalue, fn.UpvalueCount),
+		},
+		otype: O_CLOSURE,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewNative(native *ObjNative) Value {
+	o := ObjCtr{
+		_obj:  native,
+		otype: O_NATIVE,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewArray(elements []Value) Value {
+	o := ObjCtr{
+		_obj:  &ObjArray{Elements: elements},
+		otype: O_ARRAY,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewMap() Value {
+	o := ObjCtr{
+		_obj: &ObjMap{
+			Entries: make(map[MapKey]MapEntry),
+		},
+		otype: O_MAP,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+// HashKey only accepts immutable values, strings are hashed
+// by content and integral floats like ints.
+func HashKey(v *Value) (MapKey, bool) {
+	switch v.VT {
+	case VT_NIL:
+		return MapKey{vt: VT_NIL}, true
+	case VT_BOOL:
+		return MapKey{vt: VT_BOOL, _bool: v._V._bool}, true
+	case VT_INT, VT_INT8, VT_INT16, VT_INT32, VT_INT64:
+		// int8(1) == 1, so they share a key
+		return MapKey{vt: VT_INT, _int: v._V._int}, true
+	case VT_UINT8, VT_UINT16, VT_UINT32, VT_UINT64:
+		if v._V._uint <= math.MaxInt64 {
+			return MapKey{vt: VT_INT, _int: int(v._V._uint)}, true
+		}
+		return MapKey{vt: VT_UINT64, _int: int(v._V._uint)}, true
+	case VT_CHAR:
+		// 'a' != 97, chars have their own keys
+		return MapKey{vt: VT_CHAR, _int: v._V._int}, true
+	case VT_BIGINT:
+		// normalized, never equal to an int
+		return MapKey{vt: VT_BIGINT, _str: v._V._big.String()}, true
+	case VT_COMPLEX:
+		// 1+0i == 1, real values share the float key
+		if imag(v._V._c128) != 0 {
+			return MapKey{vt: VT_COMPLEX, _c128: v._V._c128}, true
+		}
+		return HashKey(&Value{_V: V{_f64: real(v._V._c128)}, VT: VT_FLOAT})
+	case VT_FLOAT:
+		f := v._V._f64
+		if f == math.Trunc(f) && !math.IsInf(f, 0) {
+			// integral floats hash like the exact int, 1e20 like
+			// the big int 100000000000000000000
+			b, _ := big.NewFloat(f).Int(nil)
+			n := NewBigInt(b)
+			return HashKey(&n)
+		}
+		return MapKey{vt: VT_FLOAT, _f64: f}, true
+	case VT_OBJ:
+		if IsString(v) {
+			return MapKey{vt: VT_OBJ, _str: *AsString(v)}, true
+		}
+	}
+	return MapKey{}, false
+}
+
+func (m *ObjMap) Get(key MapKey) (Value, bool) {
+	entry, found := m.Entries[key]
+	return entry.Value, found
+}
+
+func (m *ObjMap) Set(key MapKey, k Value, v Value) {
+	if _, found := m.Entries[key]; !found {
+		m.Order = append(m.Order, key)
+	}
+	m.Entries[key] = MapEntry{Key: k, Value: v}
+}
+
+func NewClass(name string) Value {
+	o := ObjCtr{
+		_obj: &ObjClass{
+			Name:    name,
+			Methods: make(map[string]Value),
+		},
+		otype: O_CLASS,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewInstance(class *ObjClass) Value {
+	o := ObjCtr{
+		_obj: &ObjInstance{
+			Class:  class,
+			Fields: make(map[string]Value),
+		},
+		otype: O_INSTANCE,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
+func NewBoundMethod(receiver Value, method *ObjClosure) Value {
+	o := ObjCtr{
+		_obj: &ObjBoundMethod{
+			Receiver: receiver,
+			Method:   method,
+		},
+		otype: O_BOUND_METHOD,
+	}
+	return Value{
+		_V: V{_objCtr: &o},
+		VT: VT_OBJ,
+	}
+}
+
 /*
 func ObjAsValue(o *Obj) Value {
 	return Value{_V: V{_obj: o}, VT: VT_OBJ}
 }
 */
 
+// Iterate returns the element found at cursor and the cursor
+// of the following one, strings are walked rune by rune.
+func Iterate(seq *Value, cursor int) (Value, int, bool) {
+	if IsString(seq) {
+		s := *AsString(seq)
+		if cursor >= len(s) {
+			return Value{}, cursor, false
+		}
+		ch, size := utf8.DecodeRuneInString(s[cursor:])
+		return NewString(string(ch)), cursor + size, true
+	}
+	if IsArray(seq) {
+		elements := AsArray(seq).Elements
+		if cursor >= len(elements) {
+			return Value{}, cursor, false
+		}
+		return elements[cursor], cursor + 1, true
+	}
+	if IsMap(seq) {
+		m := AsMap(seq)
+		if cursor >= len(m.Order) {
+			return Value{}, cursor, false
+		}
+		return m.Entries[m.Order[cursor]].Key, cursor + 1, true
+	}
+	return Value{}, cursor, false
+}
+
+// ToString is the text of v in interpolated strings, floats print in
+// their shortest form, 1.5 and not 1.5E+00. Integral floats keep a
+// decimal point so 1.0 does not read as the int 1.
+func ToString(v Value) string {
+	if v.VT == VT_FLOAT {
+		s := strconv.FormatFloat(v._V._f64, 'g', -1, 64)
+		if !strings.ContainsAny(s, ".eIN") {
+			s += ".0"
+		}
+		return s
+	}
+	return FormatValue(v)
+}
+
 func ConvertToString(v *Value) string {
 	return *AsString(v) //, true
 }
 
 func FreeObj(v *Value)          { v._V._objCtr = nil }
+func AsInt(v *Value) int        { return v._V._int }
+func AsChar(v *Value) rune      { return rune(v._V._int) }
 func AsString(v *Value) *string { return v._V._objCtr._obj.(*string) }
 func IsString(v *Value) bool    { return ObjType(v) == O_STRING }
+func IsInterned(v *Value) bool  { return AsObj(v).interned }
+func MarkInterned(v *Value)     { AsObj(v).interned = true }
 func ObjType(v *Value) OType    { return AsObj(v).otype }
 func AsObj(v *Value) *ObjCtr    { return v._V._objCtr }
 func IsObj(v *Value) bool       { return v.VT == VT_OBJ }
+func IsIterable(v *Value) bool  { return IsObj(v) && (IsString(v) || IsArray(v) || IsMap(v)) }
+
+func AsFunction(v *Value) *ObjFunction { return v._V._objCtr._obj.(*ObjFunction) }
+func IsFunction(v *Value) bool         { return IsObj(v) && ObjType(v) == O_FUNCTION }
+func AsClosure(v *Value) *ObjClosure   { return v._V._objCtr._obj.(*ObjClosure) }
+func IsClosure(v *Value) bool          { return IsObj(v) && ObjType(v) == O_CLOSURE }
+func AsNative(v *Value) *ObjNative     { return v._V._objCtr._obj.(*ObjNative) }
+func IsNative(v *Value) bool           { return IsObj(v) && ObjType(v) == O_NATIVE }
+func AsArray(v *Value) *ObjArray       { return v._V._objCtr._obj.(*ObjArray) }
+func IsArray(v *Value) bool            { return IsObj(v) && ObjType(v) == O_ARRAY }
+func AsMap(v *Value) *ObjMap           { return v._V._objCtr._obj.(*ObjMap) }
+func IsMap(v *Value) bool              { return IsObj(v) && ObjType(v) == O_MAP }
+func AsClass(v *Value) *ObjClass       { return v._V._objCtr._obj.(*ObjClass) }
+func IsClass(v *Value) bool            { return IsObj(v) && ObjType(v) == O_CLASS }
+func AsInstance(v *Value) *ObjInstance { return v._V._objCtr._obj.(*ObjInstance) }
+func IsInstance(v *Value) bool         { return IsObj(v) && ObjType(v) == O_INSTANCE }
+func AsBoundMethod(v *Value) *ObjBoundMethod {
+	return v._V._objCtr._obj.(*ObjBoundMethod)
+}
+func IsBoundMethod(v *Value) bool { return IsObj(v) && ObjType(v) == O_BOUND_METHOD }
 
-func IsNumberType(v VALUE_TYPE) bool             { return v == VT_FLOAT || v == VT_INT }
+func IsNumberType(v VALUE_TYPE) bool             { return IsRealType(v) || v == VT_COMPLEX }
+func IsRealType(v VALUE_TYPE) bool               { return v == VT_FLOAT || IsIntegerType(v) }
+func AsComplex(v *Value) complex128              { return v._V._c128 }
+func AsFloat(v *Value) float64                   { return v._V._f64 }
 func IsSameType(a VALUE_TYPE, b VALUE_TYPE) bool { return a == b }
 func IsBooleanType(v VALUE_TYPE) bool            { return v == VT_BOOL }
+func IsFalsey(v Value) bool                      { return v.VT == VT_NIL || (v.VT == VT_BOOL && !v._V._bool) }
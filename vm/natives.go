@@ -0,0 +1,150 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"time"
+	"unicode/utf8"
+
+	"github.com/badc0re/hprog/value"
+)
+
+// RegisterNative exposes a Go function to scripts as a global,
+// a negative arity accepts any number of arguments. Errors
+// returned by fn are reported as runtime errors. A script global
+// of the same name shadows the native.
+func (vm *VM) RegisterNative(name string, arity int, fn func(args []value.Value) (value.Value, error)) {
+	vm.natives._map[name] = value.NewNative(&value.ObjNative{
+		Name:  name,
+		Arity: arity,
+		Fn:    fn,
+	})
+}
+
+func (vm *VM) callNative(native *value.ObjNative, argCount int) bool {
+	if native.Arity >= 0 && argCount != native.Arity {
+		vm.runtimeError("%s() expected %d arguments but got %d.", native.Name, native.Arity, argCount)
+		return false
+	}
+	args := make([]value.Value, argCount)
+	copy(args, vm.vstack.Sarray[vm.vstack.Top-argCount+1:vm.vstack.Top+1])
+
+	result, err := native.Fn(args)
+	if err != nil {
+		vm.runtimeError("%s(), %s", native.Name, err)
+		return false
+	}
+	// drop the arguments and the native itself
+	vm.vstack.Top -= argCount + 1
+	vm.vstack.Push(result)
+	return true
+}
+
+func (vm *VM) defineNatives() {
+	vm.RegisterNative("clock", 0, nativeClock)
+	vm.RegisterNative("len", 1, nativeLen)
+
+	// conversions wrap around like Go, int8(200) is -56
+	integers := map[string]value.VALUE_TYPE{
+		"int":    value.VT_INT,
+		"int8":   value.VT_INT8,
+		"int16":  value.VT_INT16,
+		"int32":  value.VT_INT32,
+		"int64":  value.VT_INT64,
+		"uint":   value.VT_UINT64,
+		"uint8":  value.VT_UINT8,
+		"uint16": value.VT_UINT16,
+		"uint32": value.VT_UINT32,
+		"uint64": value.VT_UINT64,
+	}
+	for name, vt := range integers {
+		vm.RegisterNative(name, 1, nativeInteger(vt))
+	}
+	vm.RegisterNative("float", 1, nativeFloat)
+	vm.RegisterNative("char", 1, nativeChar)
+
+	vm.RegisterNative("complex", 2, nativeComplex)
+	vm.RegisterNative("real", 1, nativeReal)
+	vm.RegisterNative("imag", 1, nativeImag)
+
+	vm.defineStringNatives()
+}
+
+func nativeInteger(vt value.VALUE_TYPE) value.NativeFn {
+	return func(args []value.Value) (value.Value, error) {
+		if args[0].VT == value.VT_CHAR {
+			// the code point, int('a') is 97
+			return value.Wrap(value.NewInt(int(value.AsChar(&args[0]))), vt), nil
+		}
+		if !value.IsRealType(args[0].VT) {
+			return value.Value{}, errors.New("argument must be a real number")
+		}
+		return value.Wrap(args[0], vt), nil
+	}
+}
+
+func nativeFloat(args []value.Value) (value.Value, error) {
+	if !value.IsRealType(args[0].VT) {
+		return value.Value{}, errors.New("argument must be a real number")
+	}
+	f, _ := value.ConvertChecked(args[0], value.VT_FLOAT)
+	return f, nil
+}
+
+func nativeChar(args []value.Value) (value.Value, error) {
+	if args[0].VT == value.VT_CHAR {
+		return args[0], nil
+	}
+	code, ok := value.IntValue(&args[0])
+	if !ok {
+		return value.Value{}, errors.New("argument must be an integer")
+	}
+	if code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+		return value.Value{}, fmt.Errorf("%d is not a valid character", code)
+	}
+	return value.NewChar(rune(code)), nil
+}
+
+func nativeComplex(args []value.Value) (value.Value, error) {
+	if !value.IsRealType(args[0].VT) || !value.IsRealType(args[1].VT) {
+		return value.Value{}, errors.New("arguments must be real numbers")
+	}
+	r, _ := value.ConvertChecked(args[0], value.VT_FLOAT)
+	i, _ := value.ConvertChecked(args[1], value.VT_FLOAT)
+	return value.NewComplex(complex(value.AsFloat(&r), value.AsFloat(&i))), nil
+}
+
+func complexArg(v value.Value) (complex128, error) {
+	if !value.IsNumberType(v.VT) {
+		return 0, errors.New("argument must be a number")
+	}
+	c, _ := value.ConvertChecked(v, value.VT_COMPLEX)
+	return value.AsComplex(&c), nil
+}
+
+func nativeReal(args []value.Value) (value.Value, error) {
+	c, err := complexArg(args[0])
+	return value.NewFloat(real(c)), err
+}
+
+func nativeImag(args []value.Value) (value.Value, error) {
+	c, err := complexArg(args[0])
+	return value.NewFloat(imag(c)), err
+}
+
+func nativeClock(args []value.Value) (value.Value, error) {
+	return value.NewFloat(float64(time.Now().UnixNano()) / float64(time.Second)), nil
+}
+
+func nativeLen(args []value.Value) (value.Value, error) {
+	if value.IsObj(&args[0]) && value.IsString(&args[0]) {
+		return value.NewInt(utf8.RuneCountInString(*value.AsString(&args[0]))), nil
+	}
+	if value.IsArray(&args[0]) {
+		return value.NewInt(len(value.AsArray(&args[0]).Elements)), nil
+	}
+	if value.IsMap(&args[0]) {
+		return value.NewInt(len(value.AsMap(&args[0]).Order)), nil
+	}
+	return value.Value{}, errors.New("argument has no length")
+}
@@ -0,0 +1,151 @@
+package vm
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/badc0re/hprog/value"
+)
+
+// String natives count in runes like len, index("héllo", "l") is 2.
+func (vm *VM) defineStringNatives() {
+	vm.RegisterNative("split", 2, nativeSplit)
+	vm.RegisterNative("join", 2, nativeJoin)
+	vm.RegisterNative("contains", 2, nativeContains)
+	vm.RegisterNative("index", 2, nativeIndex)
+	vm.RegisterNative("replace", 3, nativeReplace)
+	vm.RegisterNative("upper", 1, nativeUpper)
+	vm.RegisterNative("lower", 1, nativeLower)
+	vm.RegisterNative("trim", 1, nativeTrim)
+	vm.RegisterNative("startsWith", 2, nativeStartsWith)
+	vm.RegisterNative("endsWith", 2, nativeEndsWith)
+}
+
+// substring returns the runes of s from start up to end.
+func substring(s string, start int, end int) string {
+	lo, hi := len(s), len(s)
+	i := 0
+	for offset := range s {
+		if i == start {
+			lo = offset
+		}
+		if i == end {
+			hi = offset
+			break
+		}
+		i++
+	}
+	return s[lo:hi]
+}
+
+// stringArgs reads the arguments of a native taking only strings.
+func stringArgs(args []value.Value) ([]string, error) {
+	strs := make([]string, len(args))
+	for i := range args {
+		if !(value.IsObj(&args[i]) && value.IsString(&args[i])) {
+			return nil, fmt.Errorf("argument %d must be a string", i+1)
+		}
+		strs[i] = value.ConvertToString(&args[i])
+	}
+	return strs, nil
+}
+
+func nativeSplit(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	// an empty separator splits into characters
+	parts := strings.Split(strs[0], strs[1])
+	elements := make([]value.Value, len(parts))
+	for i, part := range parts {
+		elements[i] = value.NewString(part)
+	}
+	return value.NewArray(elements), nil
+}
+
+func nativeJoin(args []value.Value) (value.Value, error) {
+	if !value.IsArray(&args[0]) {
+		return value.Value{}, errors.New("argument 1 must be an array")
+	}
+	sep, err := stringArgs(args[1:])
+	if err != nil {
+		return value.Value{}, errors.New("argument 2 must be a string")
+	}
+	elements := value.AsArray(&args[0]).Elements
+	parts, err := stringArgs(elements)
+	if err != nil {
+		return value.Value{}, errors.New("array elements must be strings")
+	}
+	return value.NewString(strings.Join(parts, sep[0])), nil
+}
+
+func nativeContains(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewBool(strings.Contains(strs[0], strs[1])), nil
+}
+
+func nativeIndex(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	i := strings.Index(strs[0], strs[1])
+	if i < 0 {
+		return value.NewInt(-1), nil
+	}
+	return value.NewInt(utf8.RuneCountInString(strs[0][:i])), nil
+}
+
+func nativeReplace(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewString(strings.ReplaceAll(strs[0], strs[1], strs[2])), nil
+}
+
+func nativeUpper(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewString(strings.ToUpper(strs[0])), nil
+}
+
+func nativeLower(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewString(strings.ToLower(strs[0])), nil
+}
+
+func nativeTrim(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewString(strings.TrimSpace(strs[0])), nil
+}
+
+func nativeStartsWith(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewBool(strings.HasPrefix(strs[0], strs[1])), nil
+}
+
+func nativeEndsWith(args []value.Value) (value.Value, error) {
+	strs, err := stringArgs(args)
+	if err != nil {
+		return value.Value{}, err
+	}
+	return value.NewBool(strings.HasSuffix(strs[0], strs[1])), nil
+}
@@ -2,6 +2,8 @@ package vm
 
 import (
 	"fmt"
+	"os"
+	"unicode/utf8"
 
 	"github.com/badc0re/hprog/chunk"
 	"github.com/badc0re/hprog/codes"
@@ -12,8 +14,9 @@ import (
 	"github.com/badc0re/hprog/value"
 )
 
-var MAX_STACK_SIZE = 256
+var MAX_FRAMES = 64
 var MAX_LOCALS_SIZE = 256
+var MAX_STACK_SIZE = MAX_FRAMES * MAX_LOCALS_SIZE
 
 type INTER_RESULT int
 
@@ -30,17 +33,68 @@ var valueTypeMap = map[OpKey]value.VALUE_TYPE{
 	OpKey{a: value.VT_INT, b: value.VT_FLOAT}:   value.VT_FLOAT,
 	OpKey{a: value.VT_FLOAT, b: value.VT_INT}:   value.VT_FLOAT,
 	OpKey{a: value.VT_INT, b: value.VT_INT}:     value.VT_INT,
+
+	OpKey{a: value.VT_INT, b: value.VT_BIGINT}:     value.VT_BIGINT,
+	OpKey{a: value.VT_BIGINT, b: value.VT_INT}:     value.VT_BIGINT,
+	OpKey{a: value.VT_FLOAT, b: value.VT_BIGINT}:   value.VT_FLOAT,
+	OpKey{a: value.VT_BIGINT, b: value.VT_FLOAT}:   value.VT_FLOAT,
+	OpKey{a: value.VT_COMPLEX, b: value.VT_BIGINT}: value.VT_COMPLEX,
+	OpKey{a: value.VT_BIGINT, b: value.VT_COMPLEX}: value.VT_COMPLEX,
+
+	OpKey{a: value.VT_INT, b: value.VT_COMPLEX}:   value.VT_COMPLEX,
+	OpKey{a: value.VT_COMPLEX, b: value.VT_INT}:   value.VT_COMPLEX,
+	OpKey{a: value.VT_FLOAT, b: value.VT_COMPLEX}: value.VT_COMPLEX,
+	OpKey{a: value.VT_COMPLEX, b: value.VT_FLOAT}: value.VT_COMPLEX,
+}
+
+func init() {
+	signed := []value.VALUE_TYPE{value.VT_INT8, value.VT_INT16, value.VT_INT32, value.VT_INT64}
+	unsigned := []value.VALUE_TYPE{value.VT_UINT8, value.VT_UINT16, value.VT_UINT32, value.VT_UINT64}
+	// mixing signed and unsigned integers is an error
+	for _, family := range [][]value.VALUE_TYPE{signed, unsigned} {
+		for i, a := range family {
+			// a plain int adopts the sized type, 1 + int8(2) is an int8
+			promote(value.VT_INT, a, a)
+			promote(value.VT_FLOAT, a, value.VT_FLOAT)
+			promote(value.VT_COMPLEX, a, value.VT_COMPLEX)
+			// the wider type of the same signedness wins
+			for _, b := range family[i+1:] {
+				promote(a, b, b)
+			}
+		}
+	}
+}
+
+func promote(a value.VALUE_TYPE, b value.VALUE_TYPE, to value.VALUE_TYPE) {
+	valueTypeMap[OpKey{a: a, b: b}] = to
+	valueTypeMap[OpKey{a: b, b: a}] = to
+}
+
+type CallFrame struct {
+	closure *value.ObjClosure
+	chunk   *chunk.Chunk
+	counter int
+	// first stack slot of the frame, holds the callee
+	slots int
 }
 
 type VM struct {
-	chunk        *chunk.Chunk
-	ip           *interface{}
-	counter      int
-	vstack       stack.Stack
+	frames     []CallFrame
+	frameCount int
+	vstack     stack.Stack
+	// sorted by stack slot, highest first
+	openUpvalues *value.ObjUpvalue
 	valueTypeMap map[OpKey]value.VALUE_TYPE
-	globals      LookupTable
-	strings      LookupTable
-	current      parser.Compiler
+	globals      GlobalTable
+	// read after globals, so scripts can declare the same names
+	natives LookupTable
+	// annotations of typed globals, decl int a
+	globalTypes map[*string]value.TypeTag
+	// interned constants and global names by contents, they live as
+	// long as the program. Strings built at runtime are not interned
+	// and compare by contents, so the table does not grow in loops.
+	strings LookupTable
+	current parser.Compiler
 }
 
 type LookupTable struct {
@@ -58,15 +112,64 @@ func (l *LookupTable) deleteObj(o string) {
 	delete(l._map, o)
 }
 
+// GlobalTable keys globals by their interned name, so a lookup
+// compares pointers instead of hashing the name.
+type GlobalTable struct {
+	_map map[*string]value.Value
+}
+
+// intern returns the string the VM keeps for the contents of v,
+// other values are returned unchanged.
+func (vm *VM) intern(v value.Value) value.Value {
+	if !value.IsObj(&v) || !value.IsString(&v) || value.IsInterned(&v) {
+		return v
+	}
+	if found := vm.strings.findObj(value.ConvertToString(&v)); found != nil {
+		return *found
+	}
+	value.MarkInterned(&v)
+	vm.strings._map[value.ConvertToString(&v)] = v
+	return v
+}
+
+// internName is the key of a global named name.
+func (vm *VM) internName(name string) *string {
+	v := vm.intern(value.NewString(name))
+	return value.AsString(&v)
+}
+
+// internConstants interns the string constants of fn and
+// of the functions declared in it.
+func (vm *VM) internConstants(fn *value.ObjFunction) {
+	constants := fn.Chunk.(*chunk.Chunk).Constants.Values
+	for i := range constants {
+		if value.IsFunction(&constants[i]) {
+			vm.internConstants(value.AsFunction(&constants[i]))
+			continue
+		}
+		constants[i] = vm.intern(constants[i])
+	}
+}
+
+// global returns the value of the global named name.
+func (vm *VM) global(name string) (value.Value, bool) {
+	v, found := vm.globals._map[vm.internName(name)]
+	return v, found
+}
+
 type OpKey struct {
 	a value.VALUE_TYPE
 	b value.VALUE_TYPE
 }
 
 func (vm *VM) InitVM() {
-	vm.globals = LookupTable{
+	vm.globals = GlobalTable{
+		_map: make(map[*string]value.Value),
+	}
+	vm.natives = LookupTable{
 		_map: make(map[string]value.Value),
 	}
+	vm.globalTypes = make(map[*string]value.TypeTag)
 	vm.strings = LookupTable{
 		_map: make(map[string]value.Value),
 	}
@@ -74,13 +177,19 @@ func (vm *VM) InitVM() {
 		Sarray: make([]value.Value, MAX_STACK_SIZE),
 		Top:    -1,
 	}
+	vm.frames = make([]CallFrame, MAX_FRAMES)
+	vm.frameCount = 0
 	vm.valueTypeMap = valueTypeMap
+	vm.defineNatives()
 }
 
 func (vm *VM) ResetStack() {
 	vm.vstack = stack.Stack{
 		Sarray: make([]value.Value, MAX_STACK_SIZE),
+		Top:    -1,
 	}
+	vm.frameCount = 0
+	vm.openUpvalues = nil
 }
 
 func (vm *VM) FreeVM() {
@@ -88,31 +197,243 @@ func (vm *VM) FreeVM() {
 	// free objects
 }
 
+func (vm *VM) frame() *CallFrame {
+	return &vm.frames[vm.frameCount-1]
+}
+
 func (vm *VM) Move() interface{} {
-	if vm.counter >= len(vm.chunk.Code) {
+	frame := vm.frame()
+	if frame.counter >= len(frame.chunk.Code) {
 		return nil
 	}
-	vm.ip = &vm.chunk.Code[vm.counter]
-	vm.counter++
-	return *vm.ip
+	instruct := frame.chunk.Code[frame.counter]
+	frame.counter++
+	return instruct
 }
 
 func (vm *VM) ReadConstant() value.Value {
-	vm.Move()
-	index := (*vm.ip).(uint)
-	return vm.chunk.Constants.Values[index]
+	index := (vm.Move()).(uint)
+	return vm.frame().chunk.Constants.Values[index]
+}
+
+func (vm *VM) runtimeError(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, "RuntimeError, "+format+"\n", args...)
+	for i := vm.frameCount - 1; i >= 0; i-- {
+		frame := &vm.frames[i]
+		name := frame.closure.Function.Name
+		if name == "" {
+			name = "script"
+		}
+		fmt.Fprintf(os.Stderr, "[line:%d] in %s\n", frame.chunk.Lines[frame.counter-1], name)
+	}
+	vm.ResetStack()
+}
+
+func (vm *VM) call(closure *value.ObjClosure, argCount int) bool {
+	fn := closure.Function
+	if argCount != fn.Arity {
+		vm.runtimeError("Expected %d arguments but got %d.", fn.Arity, argCount)
+		return false
+	}
+	if vm.frameCount == MAX_FRAMES {
+		vm.runtimeError("Stack overflow.")
+		return false
+	}
+	for i, tag := range fn.ParamTypes {
+		slot := vm.vstack.Top - argCount + 1 + i
+		v, ok := value.Coerce(vm.vstack.Sarray[slot], tag)
+		if !ok && value.OutOfRange(&vm.vstack.Sarray[slot], tag) {
+			vm.runtimeError("Argument %d of %s(), value %s overflows %s.", i+1, fn.Name, value.FormatValue(vm.vstack.Sarray[slot]), value.TTmap[tag])
+			return false
+		}
+		if !ok {
+			vm.runtimeError("Argument %d of %s() must be %s, got %s.", i+1, fn.Name, value.TTmap[tag], value.TypeName(&vm.vstack.Sarray[slot]))
+			return false
+		}
+		vm.vstack.Sarray[slot] = v
+	}
+	vm.frames[vm.frameCount] = CallFrame{
+		closure: closure,
+		chunk:   fn.Chunk.(*chunk.Chunk),
+		slots:   vm.vstack.Top - argCount,
+	}
+	vm.frameCount++
+	return true
+}
+
+// coerceTop converts the top of the stack for a variable annotated with
+// tag, locals have no name at runtime.
+func (vm *VM) coerceTop(tag value.TypeTag, name string) bool {
+	top := vm.vstack.Sarray[vm.vstack.Top]
+	v, ok := value.Coerce(top, tag)
+	if !ok {
+		if name != "" {
+			name = " '" + name + "'"
+		}
+		if value.OutOfRange(&top, tag) {
+			vm.runtimeError("Value %s overflows %s variable%s.", value.FormatValue(top), value.TTmap[tag], name)
+			return false
+		}
+		vm.runtimeError("Cannot assign %s to %s variable%s.", value.TypeName(&top), value.TTmap[tag], name)
+		return false
+	}
+	vm.vstack.Sarray[vm.vstack.Top] = v
+	return true
+}
+
+func (vm *VM) callValue(callee value.Value, argCount int) bool {
+	if value.IsClosure(&callee) {
+		return vm.call(value.AsClosure(&callee), argCount)
+	}
+	if value.IsNative(&callee) {
+		return vm.callNative(value.AsNative(&callee), argCount)
+	}
+	if value.IsClass(&callee) {
+		class := value.AsClass(&callee)
+		// the instance takes the place of the class as receiver
+		vm.vstack.Sarray[vm.vstack.Top-argCount] = value.NewInstance(class)
+		if initializer, found := class.Methods["init"]; found {
+			return vm.call(value.AsClosure(&initializer), argCount)
+		}
+		if argCount != 0 {
+			vm.runtimeError("Expected 0 arguments but got %d.", argCount)
+			return false
+		}
+		return true
+	}
+	if value.IsBoundMethod(&callee) {
+		bound := value.AsBoundMethod(&callee)
+		vm.vstack.Sarray[vm.vstack.Top-argCount] = bound.Receiver
+		return vm.call(bound.Method, argCount)
+	}
+	vm.runtimeError("Can only call functions.")
+	return false
+}
+
+func (vm *VM) bindMethod(class *value.ObjClass, name string) bool {
+	method, found := class.Methods[name]
+	if !found {
+		vm.runtimeError("Undefined property '%s'.", name)
+		return false
+	}
+	receiver := vm.vstack.Pop()
+	vm.vstack.Push(value.NewBoundMethod(receiver, value.AsClosure(&method)))
+	return true
+}
+
+func (vm *VM) captureUpvalue(slot int) *value.ObjUpvalue {
+	var prev *value.ObjUpvalue
+	upvalue := vm.openUpvalues
+	for upvalue != nil && upvalue.Slot > slot {
+		prev = upvalue
+		upvalue = upvalue.Next
+	}
+	// closures capturing the same variable share the upvalue
+	if upvalue != nil && upvalue.Slot == slot {
+		return upvalue
+	}
+
+	created := &value.ObjUpvalue{
+		Location: &vm.vstack.Sarray[slot],
+		Slot:     slot,
+		Next:     upvalue,
+	}
+	if prev == nil {
+		vm.openUpvalues = created
+	} else {
+		prev.Next = created
+	}
+	return created
+}
+
+func (vm *VM) closeUpvalues(last int) {
+	for vm.openUpvalues != nil && vm.openUpvalues.Slot >= last {
+		upvalue := vm.openUpvalues
+		upvalue.Closed = *upvalue.Location
+		upvalue.Location = &upvalue.Closed
+		vm.openUpvalues = upvalue.Next
+	}
+}
+
+func (vm *VM) arrayIndex(array *value.ObjArray, index value.Value) (int, bool) {
+	i, ok := value.IntValue(&index)
+	if !ok {
+		vm.runtimeError("Array index must be an integer.")
+		return 0, false
+	}
+	if i < 0 || i >= len(array.Elements) {
+		vm.runtimeError("Array index %d out of bounds, length %d.", i, len(array.Elements))
+		return 0, false
+	}
+	return i, true
+}
+
+// sliceBound reads a bound of s[a:b], missing when it was left out.
+func (vm *VM) sliceBound(bound value.Value, missing int) (int, bool) {
+	if bound.VT == value.VT_NIL {
+		return missing, true
+	}
+	i, ok := value.IntValue(&bound)
+	if !ok {
+		vm.runtimeError("Slice bounds must be integers.")
+	}
+	return i, ok
+}
+
+func (vm *VM) mapKey(key value.Value) (value.MapKey, bool) {
+	hashed, ok := value.HashKey(&key)
+	if !ok {
+		vm.runtimeError("Map key must be nil, a boolean, a number or a string.")
+	}
+	return hashed, ok
+}
+
+// arithmetic holds the operators that can fail, like a division by zero.
+var arithmetic = map[string]func(a *value.Value, b *value.Value) (value.Value, error){
+	"/":  value.Divide,
+	"//": value.FloorDivide,
+	"%":  value.Modulo,
+	"**": value.Power,
+	"&":  value.BitAnd,
+	"|":  value.BitOr,
+	"^":  value.BitXor,
+	"<<": value.ShiftLeft,
+	">>": value.ShiftRight,
 }
 
 func (vm *VM) binaryOP(op string) bool {
 	b := vm.vstack.Pop()
 	a := vm.vstack.Pop()
 
-	if !value.IsSameType(a.VT, b.VT) {
+	// the shift count keeps its own type, uint8(1) << 3
+	shift := op == "<<" || op == ">>"
+	if !shift && !value.IsSameType(a.VT, b.VT) {
 		vt, found := vm.valueTypeMap[OpKey{a: a.VT, b: b.VT}]
 		if !found {
+			vm.runtimeError("Operands must be of compatible types.")
 			return false
 		}
-		a, b = value.ConvertToExpectedType2(a, b, vt)
+		var fitsA, fitsB bool
+		if a, fitsA = value.ConvertChecked(a, vt); !fitsA {
+			vm.runtimeError("Value %s overflows %s.", value.FormatValue(a), value.VTmap[vt])
+			return false
+		}
+		if b, fitsB = value.ConvertChecked(b, vt); !fitsB {
+			vm.runtimeError("Value %s overflows %s.", value.FormatValue(b), value.VTmap[vt])
+			return false
+		}
+	}
+	if value.IsObj(&a) && !(op == "+" && value.IsString(&a) && value.IsString(&b)) {
+		vm.runtimeError("Operands must be numbers or strings.")
+		return false
+	}
+	if a.VT == value.VT_CHAR && op != "<" && op != ">" {
+		vm.runtimeError("Operands must be numbers or strings.")
+		return false
+	}
+	if a.VT == value.VT_COMPLEX && (op == "<" || op == ">") {
+		vm.runtimeError("Complex numbers are not ordered.")
+		return false
 	}
 
 	switch op {
@@ -120,8 +441,13 @@ func (vm *VM) binaryOP(op string) bool {
 		vm.vstack.Push(value.Add(&a, &b))
 	case "-":
 		vm.vstack.Push(value.Sub(&a, &b))
-	case "/":
-		vm.vstack.Push(value.Divide(&a, &b))
+	case "/", "//", "%", "**", "&", "|", "^", "<<", ">>":
+		result, err := arithmetic[op](&a, &b)
+		if err != nil {
+			vm.runtimeError("Cannot compute %s %s %s, %s.", value.FormatValue(a), op, value.FormatValue(b), err)
+			return false
+		}
+		vm.vstack.Push(result)
 	case "*":
 		vm.vstack.Push(value.Multiply(&a, &b))
 	case ">":
@@ -157,16 +483,19 @@ func (vm *VM) run() INTER_RESULT {
 		case codes.INSTRUC_FALSE:
 			vm.vstack.Push(value.NewBool(false))
 		case codes.INSTRUC_ERR:
+			vm.ResetStack()
 			return INTER_RUNTIME_ERROR
 		case codes.INSTRUC_NOT:
 			_v, err := vm.vstack.Peek(0)
 			if !value.IsBooleanType(_v.VT) || err != nil {
+				vm.runtimeError("Operand must be a boolean.")
 				return INTER_RUNTIME_ERROR
 			}
 			vm.vstack.Push(value.Negate(vm.vstack.Pop()))
 		case codes.INSTRUC_NEGATE:
 			a, err := vm.vstack.Peek(0)
 			if !value.IsNumberType(a.VT) || err != nil {
+				vm.runtimeError("Operand must be a number.")
 				return INTER_RUNTIME_ERROR
 			}
 			vm.vstack.Push(value.Negate(vm.vstack.Pop()))
@@ -174,11 +503,17 @@ func (vm *VM) run() INTER_RESULT {
 			b := vm.vstack.Pop()
 			a := vm.vstack.Pop()
 			if !value.IsSameType(a.VT, b.VT) {
-				vt, found := vm.valueTypeMap[OpKey{a: a.VT, b: b.VT}]
-				if !found {
-					return INTER_RUNTIME_ERROR
+				// unrelated types are never equal, e.g. a == nil
+				if vt, found := vm.valueTypeMap[OpKey{a: a.VT, b: b.VT}]; found {
+					var fitsA, fitsB bool
+					a, fitsA = value.ConvertChecked(a, vt)
+					b, fitsB = value.ConvertChecked(b, vt)
+					if !fitsA || !fitsB {
+						// out of range of the other type, int8(1) == 257
+						vm.vstack.Push(value.NewBool(false))
+						break
+					}
 				}
-				a, b = value.ConvertToExpectedType2(a, b, vt)
 			}
 			vm.vstack.Push(value.Equal(&a, &b))
 		case codes.INSTRUC_ADDITION:
@@ -197,49 +532,150 @@ func (vm *VM) run() INTER_RESULT {
 			if !vm.binaryOP("/") {
 				return INTER_RUNTIME_ERROR
 			}
+		case codes.INSTRUC_FLOOR_DIVIDE:
+			if !vm.binaryOP("//") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_MODULO:
+			if !vm.binaryOP("%") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_POWER:
+			if !vm.binaryOP("**") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_BIT_AND:
+			if !vm.binaryOP("&") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_BIT_OR:
+			if !vm.binaryOP("|") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_BIT_XOR:
+			if !vm.binaryOP("^") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_SHIFT_LEFT:
+			if !vm.binaryOP("<<") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_SHIFT_RIGHT:
+			if !vm.binaryOP(">>") {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_TO_STRING:
+			v, _ := vm.vstack.Peek(0)
+			if !(value.IsObj(&v) && value.IsString(&v)) {
+				vm.vstack.Pop()
+				vm.vstack.Push(value.NewString(value.ToString(v)))
+			}
+		case codes.INSTRUC_BIT_NOT:
+			result, err := value.BitNot(vm.vstack.Pop())
+			if err != nil {
+				vm.runtimeError("Operand must be an integer.")
+				return INTER_RUNTIME_ERROR
+			}
+			vm.vstack.Push(result)
 		case codes.INSTRUC_GREATER:
 			a, _ := vm.vstack.Peek(0)
-			if !value.IsNumberType(a.VT) {
+			if !value.IsNumberType(a.VT) && a.VT != value.VT_CHAR {
+				vm.runtimeError("Operands must be numbers or characters.")
+				return INTER_RUNTIME_ERROR
+			}
+			if !vm.binaryOP(">") {
 				return INTER_RUNTIME_ERROR
 			}
-			vm.binaryOP(">")
 		case codes.INSTRUC_LESS:
 			a, _ := vm.vstack.Peek(0)
-			if !value.IsNumberType(a.VT) {
+			if !value.IsNumberType(a.VT) && a.VT != value.VT_CHAR {
+				vm.runtimeError("Operands must be numbers or characters.")
+				return INTER_RUNTIME_ERROR
+			}
+			if !vm.binaryOP("<") {
 				return INTER_RUNTIME_ERROR
 			}
-			vm.binaryOP("<")
 		case codes.INSTRUC_DECL_GLOBAL:
 			cnst := vm.ReadConstant()
 			declName := value.AsString(&cnst)
-			v, _ := vm.vstack.Peek(0)
-			_, found := vm.globals._map[*declName]
+			tag := (vm.Move()).(value.TypeTag)
+			_, found := vm.globals._map[declName]
 			if found {
-				fmt.Println("Variable already declared", *declName)
+				vm.runtimeError("Variable already declared %s", *declName)
+				return INTER_RUNTIME_ERROR
+			}
+			if !vm.coerceTop(tag, *declName) {
 				return INTER_RUNTIME_ERROR
 			}
-			vm.globals._map[*declName] = v
+			if tag != value.TT_ANY {
+				vm.globalTypes[declName] = tag
+			}
+			v, _ := vm.vstack.Peek(0)
+			vm.globals._map[declName] = v
+			// the value lives in globals now, keep stack slots for locals
+			vm.vstack.Pop()
 		case codes.INSTRUC_SET_DECL_GLOBAL:
 			cnst := vm.ReadConstant()
 			declName := value.AsString(&cnst)
+			if _, found := vm.globals._map[declName]; !found {
+				vm.runtimeError("Variable not declared %s", *declName)
+				return INTER_RUNTIME_ERROR
+			}
+			if tag, found := vm.globalTypes[declName]; found && !vm.coerceTop(tag, *declName) {
+				return INTER_RUNTIME_ERROR
+			}
 			v, _ := vm.vstack.Peek(0)
-			vm.globals._map[*declName] = v
+			vm.globals._map[declName] = v
 		case codes.INSTRUC_GET_DECL_GLOBAL:
 			cnst := vm.ReadConstant()
 			declName := value.AsString(&cnst)
-			v, found := vm.globals._map[*declName]
+			v, found := vm.globals._map[declName]
 			if !found {
-				fmt.Println("Variable not declared", *declName)
+				if native := vm.natives.findObj(*declName); native != nil {
+					vm.vstack.Push(*native)
+					break
+				}
+				vm.runtimeError("Variable not declared %s", *declName)
 				return INTER_RUNTIME_ERROR
 			}
 			vm.vstack.Push(v)
 		case codes.INSTRUC_SET_DECL_LOCAL:
-			index := (vm.Move()).(uint)
+			index := vm.frame().slots + int((vm.Move()).(uint))
+			tag := (vm.Move()).(value.TypeTag)
+			if !vm.coerceTop(tag, "") {
+				return INTER_RUNTIME_ERROR
+			}
 			v, _ := vm.vstack.Peek(0)
 			vm.vstack.Sarray[index] = v
 		case codes.INSTRUC_GET_DECL_LOCAL:
-			index := (vm.Move()).(uint)
+			index := vm.frame().slots + int((vm.Move()).(uint))
 			vm.vstack.Push(vm.vstack.Sarray[index])
+		case codes.INSTRUC_JUMP:
+			offset := (vm.Move()).(uint)
+			vm.frame().counter += int(offset)
+		case codes.INSTRUC_JUMP_IF_FALSE:
+			offset := (vm.Move()).(uint)
+			v, _ := vm.vstack.Peek(0)
+			if value.IsFalsey(v) {
+				vm.frame().counter += int(offset)
+			}
+		case codes.INSTRUC_LOOP:
+			offset := (vm.Move()).(uint)
+			vm.frame().counter -= int(offset)
+		case codes.INSTRUC_ITER_NEXT:
+			slot := vm.frame().slots + int((vm.Move()).(uint))
+			seq := vm.vstack.Sarray[slot]
+			if !value.IsIterable(&seq) {
+				vm.runtimeError("Value is not iterable.")
+				return INTER_RUNTIME_ERROR
+			}
+			cursor := vm.vstack.Sarray[slot+1]
+			elem, next, ok := value.Iterate(&seq, value.AsInt(&cursor))
+			if ok {
+				vm.vstack.Sarray[slot+1] = value.NewInt(next)
+				vm.vstack.Sarray[slot+2] = elem
+			}
+			vm.vstack.Push(value.NewBool(ok))
 		case codes.INSTRUC_PRINT:
 			fmt.Print("PRINT, ")
 			value.PrintValue(vm.vstack.Pop())
@@ -251,21 +687,205 @@ func (vm *VM) run() INTER_RESULT {
 			fmt.Print("POP, ")
 			value.PrintValue(peek)
 			fmt.Printf("\n")
+		case codes.INSTRUC_CALL:
+			argCount := int((vm.Move()).(uint))
+			callee, _ := vm.vstack.Peek(argCount)
+			if !vm.callValue(callee, argCount) {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_CLOSURE:
+			fn := vm.ReadConstant()
+			closure := value.NewClosure(value.AsFunction(&fn))
+			upvalues := value.AsClosure(&closure).Upvalues
+			for i := range upvalues {
+				isLocal := (vm.Move()).(uint)
+				index := int((vm.Move()).(uint))
+				if isLocal == 1 {
+					upvalues[i] = vm.captureUpvalue(vm.frame().slots + index)
+				} else {
+					upvalues[i] = vm.frame().closure.Upvalues[index]
+				}
+			}
+			vm.vstack.Push(closure)
+		case codes.INSTRUC_GET_UPVALUE:
+			index := (vm.Move()).(uint)
+			vm.vstack.Push(*vm.frame().closure.Upvalues[index].Location)
+		case codes.INSTRUC_SET_UPVALUE:
+			index := (vm.Move()).(uint)
+			tag := (vm.Move()).(value.TypeTag)
+			if !vm.coerceTop(tag, "") {
+				return INTER_RUNTIME_ERROR
+			}
+			v, _ := vm.vstack.Peek(0)
+			*vm.frame().closure.Upvalues[index].Location = v
+		case codes.INSTRUC_CLOSE_UPVALUE:
+			vm.closeUpvalues(vm.vstack.Top)
+			vm.vstack.Pop()
+		case codes.INSTRUC_ARRAY:
+			count := int((vm.Move()).(uint))
+			elements := make([]value.Value, count)
+			copy(elements, vm.vstack.Sarray[vm.vstack.Top-count+1:vm.vstack.Top+1])
+			vm.vstack.Top -= count
+			vm.vstack.Push(value.NewArray(elements))
+		case codes.INSTRUC_MAP:
+			count := int((vm.Move()).(uint))
+			m := value.NewMap()
+			entries := vm.vstack.Sarray[vm.vstack.Top-2*count+1 : vm.vstack.Top+1]
+			for i := 0; i < len(entries); i += 2 {
+				key, ok := vm.mapKey(entries[i])
+				if !ok {
+					return INTER_RUNTIME_ERROR
+				}
+				value.AsMap(&m).Set(key, entries[i], entries[i+1])
+			}
+			vm.vstack.Top -= 2 * count
+			vm.vstack.Push(m)
+		case codes.INSTRUC_GET_INDEX:
+			index := vm.vstack.Pop()
+			target := vm.vstack.Pop()
+			if value.IsMap(&target) {
+				key, ok := vm.mapKey(index)
+				if !ok {
+					return INTER_RUNTIME_ERROR
+				}
+				v, found := value.AsMap(&target).Get(key)
+				if !found {
+					// missing keys read as nil
+					v = value.NewNil()
+				}
+				vm.vstack.Push(v)
+				break
+			}
+			if !value.IsArray(&target) {
+				vm.runtimeError("Only arrays and maps can be indexed.")
+				return INTER_RUNTIME_ERROR
+			}
+			array := value.AsArray(&target)
+			i, ok := vm.arrayIndex(array, index)
+			if !ok {
+				return INTER_RUNTIME_ERROR
+			}
+			vm.vstack.Push(array.Elements[i])
+		case codes.INSTRUC_SLICE:
+			hi := vm.vstack.Pop()
+			lo := vm.vstack.Pop()
+			target := vm.vstack.Pop()
+			if !(value.IsObj(&target) && value.IsString(&target)) {
+				vm.runtimeError("Only strings can be sliced.")
+				return INTER_RUNTIME_ERROR
+			}
+			s := value.ConvertToString(&target)
+			length := utf8.RuneCountInString(s)
+			start, okStart := vm.sliceBound(lo, 0)
+			end, okEnd := vm.sliceBound(hi, length)
+			if !okStart || !okEnd {
+				return INTER_RUNTIME_ERROR
+			}
+			if start < 0 || start > end || end > length {
+				vm.runtimeError("Slice bounds [%d:%d] out of range, length %d.", start, end, length)
+				return INTER_RUNTIME_ERROR
+			}
+			vm.vstack.Push(value.NewString(substring(s, start, end)))
+		case codes.INSTRUC_SET_INDEX:
+			v := vm.vstack.Pop()
+			index := vm.vstack.Pop()
+			target := vm.vstack.Pop()
+			if value.IsMap(&target) {
+				key, ok := vm.mapKey(index)
+				if !ok {
+					return INTER_RUNTIME_ERROR
+				}
+				value.AsMap(&target).Set(key, index, v)
+				vm.vstack.Push(v)
+				break
+			}
+			if !value.IsArray(&target) {
+				vm.runtimeError("Only arrays and maps can be indexed.")
+				return INTER_RUNTIME_ERROR
+			}
+			array := value.AsArray(&target)
+			i, ok := vm.arrayIndex(array, index)
+			if !ok {
+				return INTER_RUNTIME_ERROR
+			}
+			array.Elements[i] = v
+			// assignment is an expression
+			vm.vstack.Push(v)
+		case codes.INSTRUC_CLASS:
+			name := vm.ReadConstant()
+			vm.vstack.Push(value.NewClass(*value.AsString(&name)))
+		case codes.INSTRUC_METHOD:
+			name := vm.ReadConstant()
+			method, _ := vm.vstack.Peek(0)
+			class, _ := vm.vstack.Peek(1)
+			value.AsClass(&class).Methods[*value.AsString(&name)] = method
+			vm.vstack.Pop()
+		case codes.INSTRUC_GET_PROPERTY:
+			name := vm.ReadConstant()
+			target, _ := vm.vstack.Peek(0)
+			if !value.IsInstance(&target) {
+				vm.runtimeError("Only instances have properties.")
+				return INTER_RUNTIME_ERROR
+			}
+			instance := value.AsInstance(&target)
+			// fields shadow methods
+			if v, found := instance.Fields[*value.AsString(&name)]; found {
+				vm.vstack.Pop()
+				vm.vstack.Push(v)
+				break
+			}
+			if !vm.bindMethod(instance.Class, *value.AsString(&name)) {
+				return INTER_RUNTIME_ERROR
+			}
+		case codes.INSTRUC_SET_PROPERTY:
+			name := vm.ReadConstant()
+			target, _ := vm.vstack.Peek(1)
+			if !value.IsInstance(&target) {
+				vm.runtimeError("Only instances have fields.")
+				return INTER_RUNTIME_ERROR
+			}
+			v := vm.vstack.Pop()
+			value.AsInstance(&target).Fields[*value.AsString(&name)] = v
+			vm.vstack.Pop()
+			vm.vstack.Push(v)
+		case codes.INSTRUC_INHERIT:
+			superclass, _ := vm.vstack.Peek(1)
+			if !value.IsClass(&superclass) {
+				vm.runtimeError("Superclass must be a class.")
+				return INTER_RUNTIME_ERROR
+			}
+			subclass, _ := vm.vstack.Peek(0)
+			// copy down, methods defined later override these
+			for name, method := range value.AsClass(&superclass).Methods {
+				value.AsClass(&subclass).Methods[name] = method
+			}
+			vm.vstack.Pop()
+		case codes.INSTRUC_GET_SUPER:
+			name := vm.ReadConstant()
+			superclass := vm.vstack.Pop()
+			if !vm.bindMethod(value.AsClass(&superclass), *value.AsString(&name)) {
+				return INTER_RUNTIME_ERROR
+			}
 		case codes.INSTRUC_RETURN:
-			return INTER_OK
+			result := vm.vstack.Pop()
+			frame := vm.frame()
+			vm.closeUpvalues(frame.slots)
+			vm.frameCount--
+			// drop the callee, its arguments and locals
+			vm.vstack.Top = frame.slots - 1
+			if vm.frameCount == 0 {
+				return INTER_OK
+			}
+			vm.vstack.Push(result)
 		}
 		//vm.StackTrace()
 	}
 }
 
-func Compile(source string, chk *chunk.Chunk) INTER_RESULT {
+func Compile(source string) (*value.ObjFunction, INTER_RESULT) {
 	lex := lexer.Init(source)
-	comp := parser.Compiler{
-		Locals:     make([]*parser.Local, MAX_LOCALS_SIZE),
-		LocalCount: 0,
-		ScopeDepth: 0,
-	}
-	p := parser.Init(lex, chk, &comp)
+	comp := parser.NewCompiler(nil, parser.FT_SCRIPT, MAX_LOCALS_SIZE)
+	p := parser.Init(lex, comp)
 
 	p.Advance()
 	for !p.Match(token.EOF) {
@@ -275,18 +895,31 @@ func Compile(source string, chk *chunk.Chunk) INTER_RESULT {
 			break
 		}
 	}
-	p.EndCompile()
+	fn := p.EndCompile()
 
 	if p.Perror {
-		return INTER_COMPILE_ERROR
+		return nil, INTER_COMPILE_ERROR
 	}
-	return INTER_OK
+	return fn, INTER_OK
 }
 
-func (vm *VM) Interpret(source string) INTER_RESULT {
-	chk := chunk.Chunk{}
+func DissasFunction(fn *value.ObjFunction) {
+	name := fn.Name
+	if name == "" {
+		name = "INSTRUCT"
+	}
+	chk := fn.Chunk.(*chunk.Chunk)
+	chunk.DissasChunk(chk, name)
+	for _, constant := range chk.Constants.Values {
+		if value.IsFunction(&constant) {
+			DissasFunction(value.AsFunction(&constant))
+		}
+	}
+}
 
-	if Compile(source, &chk) == INTER_COMPILE_ERROR {
+func (vm *VM) Interpret(source string) INTER_RESULT {
+	fn, status := Compile(source)
+	if status == INTER_COMPILE_ERROR {
 		// parser.ppanic = true
 		// parser.perror = true
 		return INTER_COMPILE_ERROR
@@ -298,15 +931,13 @@ func (vm *VM) Interpret(source string) INTER_RESULT {
 		}
 	*/
 
-	chunk.DissasChunk(&chk, "INSTRUCT")
+	DissasFunction(fn)
+	vm.internConstants(fn)
 
-	if len(chk.Code) != 0 {
-		/* INIT START */
-		vm.chunk = &chk
-		vm.counter = 0
-		vm.ip = &vm.chunk.Code[vm.counter]
-		/* INIT END */
-		return vm.run()
-	}
-	return INTER_OK
+	/* INIT START */
+	script := value.NewClosure(fn)
+	vm.vstack.Push(script)
+	vm.call(value.AsClosure(&script), 0)
+	/* INIT END */
+	return vm.run()
 }
@@ -2,10 +2,13 @@ package vm
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
 	"testing"
+
+	"github.com/badc0re/hprog/value"
 )
 
 func BenchmarkVM(b *testing.B) {
@@ -57,3 +60,775 @@ func Execute(expression string, t *testing.T) {
 		t.Errorf("input %s", expression)
 	}
 }
+
+func TestIfElse(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 0\nif (True) { a = 1 } else { a = 2 }\n":                    value.NewInt(1),
+		"decl a = 0\nif (False) { a = 1 } else { a = 2 }\n":                   value.NewInt(2),
+		"decl a = 0\nif (nil) {\n    a = 1\n}\n":                              value.NewInt(0),
+		"decl a = 3\nif (a < 2) { a = 1 } else if (a == 3) { a = 2 }\n":       value.NewInt(2),
+		"decl a = 0\nif (1 < 2) {\n    decl b = 5\n    a = b\n}\nelse {\n}\n": value.NewInt(5),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+}
+
+func TestWhile(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 0\nwhile (a < 10) { a = a + 1 }\n":                                            value.NewInt(10),
+		"decl a = 0\nwhile (False) { a = 1 }\n":                                                 value.NewInt(0),
+		"decl a = 0\nwhile (a < 300) {\n    decl b = 1\n    decl c = b + 2\n    a = a + c\n}\n": value.NewInt(300),
+		"decl a = 0\ndecl i = 0\nwhile (i < 3) {\n    decl j = 0\n    while (j < 3) {\n        a = a + 1\n        j = j + 1\n    }\n    i = i + 1\n}\n": value.NewInt(9),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+}
+
+func TestFor(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 0\nfor (decl i = 0; i < 5; i = i + 1) { a = a + i }\n":                   value.NewInt(10),
+		"decl a = 0\nfor (decl i = 0; i < 5; i = i + 1) {\n    decl j = i\n    a = j\n}\n": value.NewInt(4),
+		"decl a = 0\ndecl i = 0\nfor (; i < 3;) { i = i + 1 }\na = i\n":                    value.NewInt(3),
+		"decl a = \"\"\nfor c in \"héllo\" { a = c + a }\n":                                value.NewString("olléh"),
+		"decl a = 0\nfor c in \"\" { a = 1 }\n":                                            value.NewInt(0),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+}
+
+func TestBreakContinue(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 0\nwhile (True) {\n    a = a + 1\n    if (a == 5) { break }\n}\n":                                                                  value.NewInt(5),
+		"decl a = 0\nfor (decl i = 0; i < 10; i = i + 1) {\n    decl j = i\n    if (j > 2) { break }\n    a = a + j\n}\n":                            value.NewInt(3),
+		"decl a = 0\nfor (decl i = 0; i < 5; i = i + 1) {\n    decl j = i\n    if (j == 2) { continue }\n    a = a + j\n}\n":                         value.NewInt(8),
+		"decl a = 0\ndecl i = 0\nwhile (i < 4) {\n    i = i + 1\n    if (i == 2) {\n        decl k = 1\n        continue\n    }\n    a = a + i\n}\n": value.NewInt(8),
+		"decl a = \"\"\nfor c in \"abcd\" {\n    if (c == \"b\") { continue }\n    if (c == \"d\") { break }\n    a = a + c\n}\n":                    value.NewString("ac"),
+		"decl a = 0\nwhile (a < 3) {\n    for (decl i = 0; i < 10; i = i + 1) { break }\n    a = a + 1\n}\n":                                         value.NewInt(3),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = []string{
+		"break\n",
+		"if (True) { continue }\n",
+	}
+	for _, source := range errorCases {
+		ExecuteStatus(source, INTER_COMPILE_ERROR, t)
+	}
+}
+
+func TestLogicalOperators(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = True and False\n":                     value.NewBool(false),
+		"decl a = False or True\n":                      value.NewBool(true),
+		"decl a = nil or 3\n":                           value.NewInt(3),
+		"decl a = 1 and 2\n":                            value.NewInt(2),
+		"decl a = 1 < 2 and 2 < 3 or False\n":           value.NewBool(true),
+		"decl a = 4\na = a != nil and a > 3\n":          value.NewBool(true),
+		"decl a = nil\na = a != nil and a > 3\n":        value.NewBool(false),
+		"decl a = False and undefined\n":                value.NewBool(false),
+		"decl a = True or undefined\n":                  value.NewBool(true),
+		"decl a = 0\nif (a == 0 or a > 10) { a = 1 }\n": value.NewInt(1),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	ExecuteStatus("decl a = True and undefined\n", INTER_RUNTIME_ERROR, t)
+}
+
+func TestFunctions(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"fn fib(n) {\n    if (n < 2) { return n }\n    return fib(n - 1) + fib(n - 2)\n}\ndecl a = fib(10)\n":                          value.NewInt(55),
+		"fn add(x, y) = x + y\ndecl a = add(2, 3)\n":                                                                                   value.NewInt(5),
+		"fn (bool) small(x) = return x < 3\ndecl a = small(1)\n":                                                                       value.NewBool(true),
+		"fn noop() {\n}\ndecl a = noop()\n":                                                                                            value.NewNil(),
+		"decl a = 0\nfn count(n) {\n    for (decl i = 0; i < n; i = i + 1) { a = a + 1 }\n}\ncount(4)\n":                               value.NewInt(4),
+		"decl a = 0\n{\n    decl x = 2\n    fn twice(y) {\n        decl z = y * 2\n        return z\n    }\n    a = twice(x) + x\n}\n": value.NewInt(6),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"return 1\n":                           INTER_COMPILE_ERROR,
+		"fn f(a) { return a }\nf(1, 2)\n":      INTER_RUNTIME_ERROR,
+		"fn f(a) { return a }\nf()\n":          INTER_RUNTIME_ERROR,
+		"decl a = 1\na()\n":                    INTER_RUNTIME_ERROR,
+		"fn f(n) { return f(n + 1) }\nf(0)\n":  INTER_RUNTIME_ERROR,
+		"fn f(a) { return a }\nprint(f + 1)\n": INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestClosures(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"fn makeCounter() {\n    decl count = 0\n    fn inc() {\n        count = count + 1\n        return count\n    }\n    return inc\n}\ndecl c = makeCounter()\nc()\ndecl a = c() + makeCounter()()\n": value.NewInt(3),
+		"fn outer() {\n    decl x = \"out\"\n    fn middle() {\n        fn inner() = x\n        return inner\n    }\n    return middle()\n}\ndecl a = outer()()\n":                                         value.NewString("out"),
+		"decl a = nil\n{\n    decl y = 1\n    fn get() = y\n    y = 5\n    a = get\n}\na = a()\n":                                                                                                          value.NewInt(5),
+		"decl a = nil\nfor (decl i = 0; i < 3; i = i + 1) {\n    decl j = i\n    fn get() = j\n    a = get\n}\na = a()\n":                                                                                  value.NewInt(2),
+		"decl a = nil\nwhile (True) {\n    decl k = 7\n    fn get() = k\n    a = get\n    break\n}\na = a()\n":                                                                                             value.NewInt(7),
+		"fn pair() {\n    decl v = 1\n    fn set(n) { v = n }\n    fn get() = v\n    set(9)\n    return get\n}\ndecl a = pair()()\n":                                                                       value.NewInt(9),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+}
+
+func TestNatives(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = len(\"héllo\")\n":                   value.NewInt(5),
+		"decl a = clock() > 0\n":                      value.NewBool(true),
+		"decl a = double(21)\n":                       value.NewInt(42),
+		"fn f(g) = g(2)\ndecl a = f(double)\n":        value.NewInt(4),
+		"decl len = 3\ndecl a = len\n":                value.NewInt(3),
+		"fn len(s) = 7\ndecl a = len(\"ab\")\n":       value.NewInt(7),
+		"decl clock = 1\nclock = 2\ndecl a = clock\n": value.NewInt(2),
+	}
+	for source, expected := range testCases {
+		v := VM{}
+		v.InitVM()
+		v.RegisterNative("double", 1, func(args []value.Value) (value.Value, error) {
+			if args[0].VT != value.VT_INT {
+				return value.Value{}, errors.New("expected an int")
+			}
+			return value.NewInt(value.AsInt(&args[0]) * 2), nil
+		})
+		if status := v.Interpret(source); status != INTER_OK {
+			t.Errorf("input %s", source)
+			continue
+		}
+		result, _ := v.global("a")
+		if value.IsFalsey(value.Equal(&result, &expected)) {
+			t.Errorf("input %s, global a not equal to expected", source)
+		}
+	}
+
+	var errorCases = []string{
+		"len(1)\n",
+		"len()\n",
+		"clock(1)\n",
+		// natives are read only, declare the name to shadow them
+		"len = 3\n",
+	}
+	for _, source := range errorCases {
+		ExecuteStatus(source, INTER_RUNTIME_ERROR, t)
+	}
+}
+
+func TestArrays(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = [1, 2, 3]\na = a[1]\n":                                          value.NewInt(2),
+		"decl a = [1, \"b\", [True]]\na = a[2][0]\n":                              value.NewBool(true),
+		"decl a = [\n    1,\n    2,\n]\na = len(a)\n":                             value.NewInt(2),
+		"decl a = [1, 2]\na[0] = 5\na = a[0] + a[1]\n":                            value.NewInt(7),
+		"decl a = [0, 0]\ndecl b = a\nb[1] = 3\na = a[1]\n":                       value.NewInt(3),
+		"decl b[]\ndecl a = len(b)\n":                                             value.NewInt(0),
+		"decl a = 0\nfor x in [1, 2, 3] { a = a + x }\n":                          value.NewInt(6),
+		"fn fill(n) {\n    decl r = []\n    return r\n}\ndecl a = len(fill(3))\n": value.NewInt(0),
+		"decl a = [[1, 2], [3, 4]]\na[1][0] = 9\na = a[1][0]\n":                   value.NewInt(9),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = []string{
+		"decl a = [1, 2]\nprint(a[2])\n",
+		"decl a = [1, 2]\nprint(a[-1])\n",
+		"decl a = [1, 2]\na[5] = 1\n",
+		"decl a = [1, 2]\nprint(a[\"0\"])\n",
+		"decl a = 1\nprint(a[0])\n",
+	}
+	for _, source := range errorCases {
+		ExecuteStatus(source, INTER_RUNTIME_ERROR, t)
+	}
+}
+
+func TestMaps(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl m = {\"a\": 1, \"b\": 2}\ndecl a = m[\"b\"]\n":                          value.NewInt(2),
+		"decl x = 3\ndecl m = {\n    \"a\": 1,\n    \"b\": x\n}\ndecl a = m[\"b\"]\n": value.NewInt(3),
+		"decl m = {}\nm[\"k\"] = \"v\"\ndecl a = m[\"k\"]\n":                          value.NewString("v"),
+		"decl m = {1: \"int\"}\ndecl a = m[1.0]\n":                                    value.NewString("int"),
+		"decl m = {100000000000000000000: 1}\ndecl a = m[100000000000000000000.0]\n":  value.NewInt(1),
+		"decl m = {100000000000000000000.0: 1}\ndecl a = m[100000000000000000000]\n":  value.NewInt(1),
+		"decl m = {-0.0: 1}\ndecl a = m[0]\n":                                         value.NewInt(1),
+		"decl m = {1.5: 1}\ndecl a = m[1]\n":                                          value.NewNil(),
+		"decl m = {True: 1, nil: 2}\ndecl a = m[nil]\n":                               value.NewInt(2),
+		"decl m = {\"a\": 1}\ndecl a = m[\"missing\"]\n":                              value.NewNil(),
+		"decl m = {\"a\": 1, \"b\": 2}\nm[\"a\"] = 5\ndecl a = len(m)\n":              value.NewInt(2),
+		"decl m = {\"x\": 1, \"y\": 2}\ndecl a = \"\"\nfor k in m { a = a + k }\n":    value.NewString("xy"),
+		"decl m = {\"l\": [1, {\"n\": 4}]}\ndecl a = m[\"l\"][1][\"n\"]\n":            value.NewInt(4),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = []string{
+		"decl m = {[1]: 1}\n",
+		"decl m = {}\nm[[1]] = 1\n",
+		"decl m = {}\nprint(m[{}])\n",
+	}
+	for _, source := range errorCases {
+		ExecuteStatus(source, INTER_RUNTIME_ERROR, t)
+	}
+}
+
+func TestClasses(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"class Point { fn init(x, y) { this.x = x } }\ndecl a = Point(1, 2).x\n": value.NewInt(1),
+		"class Empty {\n}\ndecl e = Empty()\ne.f = 3\ndecl a = e.f\n":            value.NewInt(3),
+		"class C {\n    fn init(n) {\n        this.n = n\n    }\n    fn inc(by) {\n        this.n = this.n + by\n        return this\n    }\n}\ndecl a = C(1).inc(2).inc(3).n\n":         value.NewInt(6),
+		"class C {\n    fn init() { this.n = 1 }\n    fn get() = this.n\n}\ndecl c = C()\ndecl g = c.get\nc.n = 7\ndecl a = g()\n":                                                       value.NewInt(7),
+		"class C {\n    fn init() { this.n = 0 }\n    fn adder() {\n        fn add(k) { this.n = this.n + k }\n        return add\n    }\n}\ndecl c = C()\nc.adder()(5)\ndecl a = c.n\n": value.NewInt(5),
+		"class C {\n    fn init() {\n        this.v = 1\n        return\n    }\n}\ndecl a = C().init().v\n":                                                                              value.NewInt(1),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"print(this)\n": INTER_COMPILE_ERROR,
+		"class C {\n    fn init() { return 1 }\n}\n":         INTER_COMPILE_ERROR,
+		"class C {\n}\nprint(C().missing)\n":                 INTER_RUNTIME_ERROR,
+		"class C {\n}\nC(1)\n":                               INTER_RUNTIME_ERROR,
+		"class C {\n    fn init(a) { this.a = a }\n}\nC()\n": INTER_RUNTIME_ERROR,
+		"decl a = 1\nprint(a.b)\n":                           INTER_RUNTIME_ERROR,
+		"decl a = 1\na.b = 2\n":                              INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestInheritance(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"class A {\n    fn name() = \"A\"\n}\nclass B < A {\n}\ndecl a = B().name()\n":                                                                                                 value.NewString("A"),
+		"class A {\n    fn name() = \"A\"\n}\nclass B < A {\n    fn name() = \"B\" + super.name()\n}\ndecl a = B().name()\n":                                                           value.NewString("BA"),
+		"class A {\n    fn init(x) { this.x = x }\n}\nclass B < A {\n    fn init(x, y) {\n        super.init(x)\n        this.y = y\n    }\n}\ndecl b = B(1, 2)\ndecl a = b.x + b.y\n": value.NewInt(3),
+		"class A {\n    fn f() = 1\n}\nclass B < A {\n    fn f() = super.f() + 1\n}\nclass C < B {\n    fn f() = super.f() + 1\n}\ndecl a = C().f()\n":                                 value.NewInt(3),
+		"class A {\n    fn f() = 1\n}\nclass B < A {\n    fn g() {\n        decl m = super.f\n        return m\n    }\n}\ndecl a = B().g()()\n":                                        value.NewInt(1),
+		"decl a = 0\n{\n    class A {\n        fn f() = 2\n    }\n    class B < A {\n        fn f() = super.f() * 2\n    }\n    a = B().f()\n}\n":                                      value.NewInt(4),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"class A < A {\n}\n":                     INTER_COMPILE_ERROR,
+		"class A {\n    fn f() = super.f()\n}\n": INTER_COMPILE_ERROR,
+		"print(super.f)\n":                       INTER_COMPILE_ERROR,
+		"decl A = 1\nclass B < A {\n}\n":         INTER_RUNTIME_ERROR,
+		"class A {\n}\nclass B < A {\n    fn f() = super.f()\n}\nB().f()\n": INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestTypeAnnotations(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl int a = 10\n":              value.NewInt(10),
+		"decl int a\n":                   value.NewInt(0),
+		"decl float a = 1\n":             value.NewFloat(1),
+		"decl float a\na = 2\n":          value.NewFloat(2),
+		"decl string a\na = a + \"x\"\n": value.NewString("x"),
+		"decl bool a = 1 < 2\n":          value.NewBool(true),
+		"decl a = 0\n{\n    decl int b = 3\n    b = b + 1\n    a = b\n}\n": value.NewInt(4),
+		"fn f(int x, y) = x + y\ndecl a = f(1, 2)\n":                       value.NewInt(3),
+		"fn f(float x) = x\ndecl a = f(2)\n":                               value.NewFloat(2),
+		"decl string b[]\ndecl a = len(b)\n":                               value.NewInt(0),
+		"decl int = 5\ndecl a = int\n":                                     value.NewInt(5),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl int a = \"x\"\n":  INTER_COMPILE_ERROR,
+		"decl int a\na = 1.5\n": INTER_COMPILE_ERROR,
+		"decl foo a = 1\n":      INTER_COMPILE_ERROR,
+		"{\n    decl string s = \"\"\n    decl int i = s\n}\n":                      INTER_COMPILE_ERROR,
+		"decl int a = 1\nfn f() { a = \"x\" }\nf()\n":                               INTER_COMPILE_ERROR,
+		"decl b = \"x\"\ndecl int a = b\n":                                          INTER_RUNTIME_ERROR,
+		"decl int a = 1\ndecl b = True\na = b\n":                                    INTER_RUNTIME_ERROR,
+		"fn f() {\n    decl int x = 1\n    fn g(v) { x = v }\n    g(nil)\n}\nf()\n": INTER_RUNTIME_ERROR,
+		"fn f(int x) = x\nf(\"x\")\n":                                               INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestSizedIntegers(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl int8 a = 127\na = a + 1\n":          value.NewSized(-128, value.VT_INT8),
+		"decl uint8 a\na = a - 1\n":               value.NewUnsigned(255, value.VT_UINT8),
+		"decl a = int8(200)\n":                    value.NewSized(-56, value.VT_INT8),
+		"decl a = uint16(-1)\n":                   value.NewUnsigned(65535, value.VT_UINT16),
+		"decl a = int32(2.9)\n":                   value.NewSized(2, value.VT_INT32),
+		"decl a = int8(1) + int32(2)\n":           value.NewSized(3, value.VT_INT32),
+		"decl a = uint8(250) + 10\n":              value.NewUnsigned(4, value.VT_UINT8),
+		"decl a = int16(3) * 1.5\n":               value.NewFloat(4.5),
+		"decl a = int8(-1) < 0\n":                 value.NewBool(true),
+		"decl a = uint32(7) == 7\n":               value.NewBool(true),
+		"decl a = int8(1) == 1000\n":              value.NewBool(false),
+		"decl a = int8(-24) == 1000\n":            value.NewBool(false),
+		"decl a = int8(1) == 257\n":               value.NewBool(false),
+		"decl a = 257 == int8(1)\n":               value.NewBool(false),
+		"decl a = uint8(1) == -255\n":             value.NewBool(false),
+		"decl a = {int8(1): 1}[257]\n":            value.NewNil(),
+		"decl a = int(uint64(5)) + 1\n":           value.NewInt(6),
+		"decl a = -int64(5)\n":                    value.NewSized(-5, value.VT_INT64),
+		"decl b = [1, 2]\ndecl a = b[uint8(1)]\n": value.NewInt(2),
+		"decl uint a = 1\ndecl a2 = a\n":          value.NewUnsigned(1, value.VT_UINT64),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = int8(1) + uint8(1)\n":   INTER_RUNTIME_ERROR,
+		"decl a = int8(1) + 1000\n":       INTER_RUNTIME_ERROR,
+		"decl a = uint8(1) + -1\n":        INTER_RUNTIME_ERROR,
+		"decl int8 a = 300\n":             INTER_RUNTIME_ERROR,
+		"decl uint8 a = -1\n":             INTER_RUNTIME_ERROR,
+		"decl int8 a = 1\na = int16(1)\n": INTER_RUNTIME_ERROR,
+		"decl a = int8(\"x\")\n":          INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestComplex(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 3+4i\n":                         value.NewComplex(3 + 4i),
+		"decl a = 1.5 - 2i\n":                     value.NewComplex(1.5 - 2i),
+		"decl a = (1+2i) * (3-1i)\n":              value.NewComplex(5 + 5i),
+		"decl a = (4+2i) / 2\n":                   value.NewComplex(2 + 1i),
+		"decl a = -(1+1i)\n":                      value.NewComplex(-1 - 1i),
+		"decl a = 2i * 2i == -4\n":                value.NewBool(true),
+		"decl a = int8(2) + 1i\n":                 value.NewComplex(2 + 1i),
+		"decl a = complex(1, 2)\n":                value.NewComplex(1 + 2i),
+		"decl a = real(3+4i) + imag(3+4i)\n":      value.NewFloat(7),
+		"decl a = 1i + uint8(1)\n":                value.NewComplex(1 + 1i),
+		"decl complex a = 2\n":                    value.NewComplex(2),
+		"decl m = {1: \"x\"}\ndecl a = m[1+0i]\n": value.NewString("x"),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 1i < 2i\n":  INTER_RUNTIME_ERROR,
+		"decl a = int(1i)\n":  INTER_RUNTIME_ERROR,
+		"decl float a = 1i\n": INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestIntegerLiterals(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 0xFF\n":              value.NewInt(255),
+		"decl a = 0o17\n":              value.NewInt(15),
+		"decl a = 0b1010\n":            value.NewInt(10),
+		"decl a = 1_000_000\n":         value.NewInt(1000000),
+		"decl a = 0x_7f + 1\n":         value.NewInt(128),
+		"decl a = 1_0.2_5\n":           value.NewFloat(10.25),
+		"decl a = 010\n":               value.NewInt(10),
+		"decl uint8 a = 0b1111_1111\n": value.NewUnsigned(255, value.VT_UINT8),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 0x\n":   INTER_COMPILE_ERROR,
+		"decl a = 0b12\n": INTER_COMPILE_ERROR,
+		"decl a = 1__0\n": INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestBigIntegers(t *testing.T) {
+	big := func(s string) value.Value { return value.New(s, value.VT_BIGINT) }
+	var testCases = map[string]value.Value{
+		"decl a = 9223372036854775807 + 1\n":                                           big("9223372036854775808"),
+		"decl a = -9223372036854775807 - 2\n":                                          big("-9223372036854775809"),
+		"decl a = 4294967296 * 4294967296\n":                                           big("18446744073709551616"),
+		"decl a = (9223372036854775807 + 1) - 1\n":                                     value.NewInt(9223372036854775807),
+		"decl a = 100000000000000000000 / 10\n":                                        big("10000000000000000000"),
+		"decl a = 100000000000000000000 / 100\n":                                       value.NewInt(1000000000000000000),
+		"decl a = 0xFFFFFFFFFFFFFFFFFF\n":                                              big("4722366482869645213695"),
+		"decl a = 1_000_000_000_000_000_000_000\n":                                     big("1000000000000000000000"),
+		"decl a = -(-9223372036854775807 - 1)\n":                                       big("9223372036854775808"),
+		"decl a = 100000000000000000000 > 1\n":                                         value.NewBool(true),
+		"decl a = 100000000000000000000 < 100000000000000000001\n":                     value.NewBool(true),
+		"decl a = 100000000000000000000 == 10000000000 * 10000000000\n":                value.NewBool(true),
+		"decl a = 100000000000000000000 == 100000000000000000000.0\n":                  value.NewBool(true),
+		"decl a = 100000000000000000000 + 0.5\n":                                       value.NewFloat(1e20),
+		"decl int a = 1\na = a * 100000000000000000000\n":                              big("100000000000000000000"),
+		"decl a = int8(100000000000000000000)\n":                                       value.NewSized(0, value.VT_INT8),
+		"decl a = int(100000000000000000000.0)\n":                                      big("100000000000000000000"),
+		"decl a = 1\nfor (decl i = 0; i < 30; i = i + 1) {\n    a = a * 10\n}\n":       big("1000000000000000000000000000000"),
+		"decl m = {100000000000000000000: 1}\ndecl a = m[10000000000 * 10000000000]\n": value.NewInt(1),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 100000000000000000000 + int8(1)\n": INTER_RUNTIME_ERROR,
+		"decl int8 a = 100000000000000000000\n":      INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestArithmeticOperators(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 1 ** 100000000000000000000\n":    value.NewInt(1),
+		"decl a = (-1) ** 10000000001\n":           value.NewInt(-1),
+		"decl a = (2 ** 1000000) // 2 ** 999999\n": value.NewInt(2),
+		"decl a = 7 % 3\n":                         value.NewInt(1),
+		"decl a = -7 % 2\n":                        value.NewInt(1),
+		"decl a = 7 % -2\n":                        value.NewInt(-1),
+		"decl a = 7.5 % 2\n":                       value.NewFloat(1.5),
+		"decl a = 7 // 2\n":                        value.NewInt(3),
+		"decl a = -7 // 2\n":                       value.NewInt(-4),
+		"decl a = 7.0 // 2\n":                      value.NewFloat(3),
+		"decl a = (-7 // 2) * 2 + -7 % 2\n":        value.NewInt(-7),
+		"decl a = 2 ** 10\n":                       value.NewInt(1024),
+		"decl a = 2 ** 3 ** 2\n":                   value.NewInt(512),
+		"decl a = -2 ** 2\n":                       value.NewInt(-4),
+		"decl a = 2 * 3 ** 2\n":                    value.NewInt(18),
+		"decl a = 2 ** -1\n":                       value.NewFloat(0.5),
+		"decl a = 4.0 ** 0.5\n":                    value.NewFloat(2),
+		"decl a = 2 ** 64\n":                       value.New("18446744073709551616", value.VT_BIGINT),
+		"decl a = int8(2) ** 7\n":                  value.NewSized(-128, value.VT_INT8),
+		"decl a = uint8(3) ** 6\n":                 value.NewUnsigned(217, value.VT_UINT8),
+		"decl a = int8(-7) % 2\n":                  value.NewSized(1, value.VT_INT8),
+		"decl a = real(1i ** 2)\n":                 value.NewFloat(-1),
+		"decl a = 100000000000000000000 % 7\n":     value.NewInt(2),
+		"decl a = -100000000000000000000 // 3\n":   value.New("-33333333333333333334", value.VT_BIGINT),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 1 / 0\n":                      INTER_RUNTIME_ERROR,
+		"decl a = 1.0 / 0\n":                    INTER_RUNTIME_ERROR,
+		"decl a = 1 % 0\n":                      INTER_RUNTIME_ERROR,
+		"decl a = 1 // 0.0\n":                   INTER_RUNTIME_ERROR,
+		"decl a = uint8(1) / uint8(0)\n":        INTER_RUNTIME_ERROR,
+		"decl a = 100000000000000000000 / 0\n":  INTER_RUNTIME_ERROR,
+		"decl a = 1i % 2\n":                     INTER_RUNTIME_ERROR,
+		"decl a = int8(2) ** -1\n":              INTER_RUNTIME_ERROR,
+		"decl a = 10 ** 10000000000\n":          INTER_RUNTIME_ERROR,
+		"decl a = 2 ** 100000000000000000000\n": INTER_RUNTIME_ERROR,
+		"decl a = \"a\" % 2\n":                  INTER_RUNTIME_ERROR,
+		"decl a = True ** True\n":               INTER_RUNTIME_ERROR,
+		"decl a = True / True\n":                INTER_RUNTIME_ERROR,
+		"decl a = True % True\n":                INTER_RUNTIME_ERROR,
+		"decl a = True // True\n":               INTER_RUNTIME_ERROR,
+		"decl a = nil ** nil\n":                 INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestBitwiseOperators(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 12 & 10\n":                                    value.NewInt(8),
+		"decl a = 12 | 3\n":                                     value.NewInt(15),
+		"decl a = 12 ^ 10\n":                                    value.NewInt(6),
+		"decl a = ~5\n":                                         value.NewInt(-6),
+		"decl a = 1 << 4\n":                                     value.NewInt(16),
+		"decl a = -8 >> 1\n":                                    value.NewInt(-4),
+		"decl a = 1 << 64\n":                                    value.New("18446744073709551616", value.VT_BIGINT),
+		"decl a = (1 << 64) >> 60\n":                            value.NewInt(16),
+		"decl a = (1 << 1048576) >> 1048576\n":                  value.NewInt(1),
+		"decl a = (1 << 64) | 1 == (1 << 64) + 1\n":             value.NewBool(true),
+		"decl a = uint8(1) << 9\n":                              value.NewUnsigned(0, value.VT_UINT8),
+		"decl a = ~uint8(0)\n":                                  value.NewUnsigned(255, value.VT_UINT8),
+		"decl a = int8(-128) >> 7\n":                            value.NewSized(-1, value.VT_INT8),
+		"decl a = uint16(0xF0F0) & 0xFF\n":                      value.NewUnsigned(0xF0, value.VT_UINT16),
+		"decl a = 1 | 2 ^ 3 & 4\n":                              value.NewInt(3),
+		"decl a = 1 + 2 << 1\n":                                 value.NewInt(6),
+		"decl a = 6 & 3 == 2\n":                                 value.NewBool(true),
+		"decl flags = 0b0101\ndecl a = (flags >> 2) & 1 == 1\n": value.NewBool(true),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 1.5 & 1\n":            INTER_RUNTIME_ERROR,
+		"decl a = ~1.5\n":               INTER_RUNTIME_ERROR,
+		"decl a = 1 << -1\n":            INTER_RUNTIME_ERROR,
+		"decl a = 1 << 1.0\n":           INTER_RUNTIME_ERROR,
+		"decl a = 1 << 100000000000\n":  INTER_RUNTIME_ERROR,
+		"decl a = 1 << uint64(-1)\n":    INTER_RUNTIME_ERROR,
+		"decl a = int8(1) | uint8(1)\n": INTER_RUNTIME_ERROR,
+		"decl a = \"a\" | 1\n":          INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestTernary(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 1 < 2 ? \"yes\" : \"no\"\n":                                              value.NewString("yes"),
+		"decl a = nil ? 1 : 2\n":                                                           value.NewInt(2),
+		"decl a = False ? 1 : True ? 2 : 3\n":                                              value.NewInt(2),
+		"decl a = True ? False ? 1 : 2 : 3\n":                                              value.NewInt(2),
+		"decl a = 1 > 2 or True ? 1 + 1 : 0\n":                                             value.NewInt(2),
+		"decl a = 0\nfn f() {\n    a = a + 1\n    return 1\n}\ndecl b = True ? 5 : f()\n":  value.NewInt(0),
+		"decl a = 0\nfn f() {\n    a = a + 1\n    return 1\n}\ndecl b = False ? f() : 5\n": value.NewInt(0),
+		"fn max(x, y) = x > y ? x : y\ndecl a = max(3, 7)\n":                               value.NewInt(7),
+		"decl c = 3\nfn (bool) test() = return 1 < c ? True : False\ndecl a = test()\n":    value.NewBool(true),
+		"decl a\na = True ? 1 :\n    2\n":                                                  value.NewInt(1),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = True ? 1\n":   INTER_COMPILE_ERROR,
+		"decl a = True ? 1 2\n": INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestStringLiterals(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = \"x\\ty\"\n":                        value.NewString("x\ty"),
+		"decl a = len(\"caf\\u00e9\")\n":              value.NewInt(4),
+		"decl a = \"\"\"one\ntwo\"\"\"\ndecl b = 1\n": value.NewString("one\ntwo"),
+		"decl a = `C:\\dir\\n`\n":                     value.NewString(`C:\dir\n`),
+		"decl a = \"\\\"\" + `\"`\n":                  value.NewString(`""`),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = \"\\x\"\n":    INTER_COMPILE_ERROR,
+		"decl a = \"\"\"open\n": INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestStringInterpolation(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl name = \"Ana\"\ndecl a = \"Hello ${name}!\"\n":   value.NewString("Hello Ana!"),
+		"decl a = \"${1 + 2}\"\n":                              value.NewString("3"),
+		"decl a = \"${1.5} ${True} ${nil}\"\n":                 value.NewString("1.5 true nil"),
+		"decl a = \"${1.0} ${1} ${-2.0}\"\n":                   value.NewString("1.0 1 -2.0"),
+		"decl a = \"${1 / 2.0} ${100000000000000000000.0}\"\n": value.NewString("0.5 1e+20"),
+		"decl a = \"${\"x\"}${\"y\"}\"\n":                      value.NewString("xy"),
+		"decl a = \"outer ${\"inner ${1}\"}\"\n":               value.NewString("outer inner 1"),
+		"decl m = {\"k\": 2}\ndecl a = \"v=${m[\"k\"]}\"\n":    value.NewString("v=2"),
+		"decl a = \"${ {1: 2}[1] }\"\n":                        value.NewString("2"),
+		"decl a = \"cost: \\${5}\"\n":                          value.NewString("cost: ${5}"),
+		"decl a = \"\"\"line ${1}\nline ${2}\"\"\"\n":          value.NewString("line 1\nline 2"),
+		"decl a = `${1}`\n":                                    value.NewString("${1}"),
+		"decl a = \"${[1, 2]}\"\n":                             value.NewString("[1, 2]"),
+		"decl a = \"${100000000000000000000}\"\n":              value.NewString("100000000000000000000"),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = \"${}\"\n":    INTER_COMPILE_ERROR,
+		"decl a = \"${1\n":      INTER_COMPILE_ERROR,
+		"decl a = \"${1 2}\"\n": INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestStringInterning(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = \"a\" + \"b\" == \"ab\"\n":                               value.NewBool(true),
+		"decl a = \"${1}\" == \"1\"\n":                                     value.NewBool(true),
+		"decl a = \"a\" == \"b\"\n":                                        value.NewBool(false),
+		"decl m = {\"ab\": 1}\ndecl a = m[\"a\" + \"b\"]\n":                value.NewInt(1),
+		"decl a = 0\nfor c in \"aba\" { if (c == \"a\") { a = a + 1 } }\n": value.NewInt(2),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	// constants with the same contents share one object
+	v := VM{}
+	v.InitVM()
+	source := "decl a = \"ab\"\ndecl b = \"ab\"\ndecl c = \"a\" + \"b\"\n"
+	if status := v.Interpret(source); status != INTER_OK {
+		t.Fatalf("input %s", source)
+	}
+	a, _ := v.global("a")
+	if b, _ := v.global("b"); !value.IsInterned(&b) || value.AsObj(&b) != value.AsObj(&a) {
+		t.Errorf("input %s, global b is not interned", source)
+	}
+	// runtime strings are not kept, they compare by contents
+	c, _ := v.global("c")
+	if value.IsInterned(&c) || value.IsFalsey(value.Equal(&c, &a)) {
+		t.Errorf("input %s, global c is interned or not equal to a", source)
+	}
+	// a later chunk reuses the constants of the earlier ones
+	if status := v.Interpret("decl d = \"ab\"\n"); status != INTER_OK {
+		t.Fatalf("input decl d")
+	}
+	if d, _ := v.global("d"); value.AsObj(&d) != value.AsObj(&a) {
+		t.Errorf("global d is not interned")
+	}
+	// building strings in a loop does not grow the table
+	before := len(v.strings._map)
+	if status := v.Interpret("decl s = \"\"\nfor i in [1, 2, 3, 4, 5, 6, 7, 8] { s = s + \"x\" }\n"); status != INTER_OK {
+		t.Fatalf("input decl s")
+	}
+	// the constants s, i and "x"
+	if grown := len(v.strings._map) - before; grown > 3 {
+		t.Errorf("%d strings interned by the loop", grown)
+	}
+}
+
+func TestStringLibrary(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = len(\"héllo\")\n":                           value.NewInt(5),
+		"decl a = \"héllo\"[1:3]\n":                           value.NewString("él"),
+		"decl a = \"héllo\"[:2]\n":                            value.NewString("hé"),
+		"decl a = \"héllo\"[3:]\n":                            value.NewString("lo"),
+		"decl a = \"héllo\"[:]\n":                             value.NewString("héllo"),
+		"decl a = \"abc\"[3:]\n":                              value.NewString(""),
+		"decl s = \"abc\"\ndecl i = 1\ndecl a = s[i:i + 1]\n": value.NewString("b"),
+		"decl a = len(split(\"a,b,c\", \",\"))\n":             value.NewInt(3),
+		"decl a = split(\"añb\", \"\")[1]\n":                  value.NewString("ñ"),
+		"decl a = join(split(\"a b c\", \" \"), \"-\")\n":     value.NewString("a-b-c"),
+		"decl a = join([], \",\")\n":                          value.NewString(""),
+		"decl a = contains(\"héllo\", \"él\")\n":              value.NewBool(true),
+		"decl a = contains(\"héllo\", \"x\")\n":               value.NewBool(false),
+		"decl a = index(\"héllo\", \"l\")\n":                  value.NewInt(2),
+		"decl a = index(\"héllo\", \"x\")\n":                  value.NewInt(-1),
+		"decl a = replace(\"a-b-c\", \"-\", \"+\")\n":         value.NewString("a+b+c"),
+		"decl a = upper(\"héllo\")\n":                         value.NewString("HÉLLO"),
+		"decl a = lower(\"ÉCOLE\")\n":                         value.NewString("école"),
+		"decl a = trim(\"  x y \\n\")\n":                      value.NewString("x y"),
+		"decl a = startsWith(\"héllo\", \"hé\")\n":            value.NewBool(true),
+		"decl a = endsWith(\"héllo\", \"hé\")\n":              value.NewBool(false),
+		"decl a = upper(\"ab\")[1:] == \"B\"\n":               value.NewBool(true),
+		// scripts may reuse the names of natives
+		"decl index = 0\ndecl a = index\n":                                  value.NewInt(0),
+		"fn split(s) = 1\ndecl a = split(\"x\")\n":                          value.NewInt(1),
+		"fn f() {\n    decl index = 2\n    return index\n}\ndecl a = f()\n": value.NewInt(2),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = \"abc\"[2:1]\n":        INTER_RUNTIME_ERROR,
+		"decl a = \"abc\"[0:4]\n":        INTER_RUNTIME_ERROR,
+		"decl a = \"abc\"[-1:]\n":        INTER_RUNTIME_ERROR,
+		"decl a = \"abc\"[0.5:]\n":       INTER_RUNTIME_ERROR,
+		"decl a = [1, 2][0:1]\n":         INTER_RUNTIME_ERROR,
+		"decl a = \"abc\"[0:1\n":         INTER_COMPILE_ERROR,
+		"decl a = split(1, \",\")\n":     INTER_RUNTIME_ERROR,
+		"decl a = join([1, 2], \",\")\n": INTER_RUNTIME_ERROR,
+		"decl a = join(\"ab\", \",\")\n": INTER_RUNTIME_ERROR,
+		"decl a = upper()\n":             INTER_RUNTIME_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func TestChars(t *testing.T) {
+	var testCases = map[string]value.Value{
+		"decl a = 'x'\n":                              value.NewChar('x'),
+		"decl a = '\\n'\n":                            value.NewChar('\n'),
+		"decl a = 'é' == 'é'\n":                       value.NewBool(true),
+		"decl a = 'a' == 'b'\n":                       value.NewBool(false),
+		"decl a = 'a' == \"a\"\n":                     value.NewBool(false),
+		"decl a = 'a' == 97\n":                        value.NewBool(false),
+		"decl a = int('a') == 97\n":                   value.NewBool(true),
+		"decl a = 97 == 'a'\n":                        value.NewBool(false),
+		"decl char = 'a'\ndecl a = char\n":            value.NewChar('a'),
+		"decl char = 1\ndecl a = char + 1\n":          value.NewInt(2),
+		"decl a = 'a' < 'b'\n":                        value.NewBool(true),
+		"decl a = 'z' > 'é'\n":                        value.NewBool(false),
+		"decl a = int('a')\n":                         value.NewInt(97),
+		"decl a = int8('é')\n":                        value.Wrap(value.NewInt(-23), value.VT_INT8),
+		"decl a = char(97)\n":                         value.NewChar('a'),
+		"decl a = char(int('a') + 1)\n":               value.NewChar('b'),
+		"decl a = \"${'h'}i\"\n":                      value.NewString("hi"),
+		"decl char a = 'q'\n":                         value.NewChar('q'),
+		"decl char a\n":                               value.NewChar(0),
+		"decl m = {'a': 1, 97: 2}\ndecl a = m['a']\n": value.NewInt(1),
+	}
+	for source, expected := range testCases {
+		ExecuteGlobal(source, "a", expected, t)
+	}
+
+	var errorCases = map[string]INTER_RESULT{
+		"decl a = 'a' + 'b'\n":     INTER_RUNTIME_ERROR,
+		"decl a = 'a' + 1\n":       INTER_RUNTIME_ERROR,
+		"decl a = 'a' < 1\n":       INTER_RUNTIME_ERROR,
+		"decl a = -'a'\n":          INTER_RUNTIME_ERROR,
+		"decl a = char(-1)\n":      INTER_RUNTIME_ERROR,
+		"decl a = char(1.5)\n":     INTER_RUNTIME_ERROR,
+		"decl a = char(1114112)\n": INTER_RUNTIME_ERROR,
+		"decl char a = \"a\"\n":    INTER_COMPILE_ERROR,
+		"decl int a = 'a'\n":       INTER_COMPILE_ERROR,
+		"decl a = ''\n":            INTER_COMPILE_ERROR,
+		"decl a = 'ab'\n":          INTER_COMPILE_ERROR,
+	}
+	for source, expected := range errorCases {
+		ExecuteStatus(source, expected, t)
+	}
+}
+
+func ExecuteStatus(expression string, expected INTER_RESULT, t *testing.T) {
+	v := VM{}
+	v.InitVM()
+	status := v.Interpret(expression)
+	if status != expected {
+		t.Errorf("input %s, status: %d, expected: %d", expression, status, expected)
+	}
+}
+
+func ExecuteGlobal(expression string, name string, expected value.Value, t *testing.T) {
+	v := VM{}
+	v.InitVM()
+	status := v.Interpret(expression)
+	if status != INTER_OK {
+		t.Errorf("input %s", expression)
+		return
+	}
+	result, found := v.global(name)
+	if !found || value.IsFalsey(value.Equal(&result, &expected)) {
+		t.Errorf("input %s, global %s not equal to expected", expression, name)
+	}
+}